@@ -0,0 +1,91 @@
+// Copyright 2022 Matrix Origin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/util/retry"
+	"k8s.io/utils/strings/slices"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+// cleaner lists the objects holding any of the finalizers and strips them if requested
+type cleaner struct {
+	cli        client.Client
+	out        io.Writer
+	finalizers []string
+	namespace  string
+	strip      bool
+}
+
+func (c *cleaner) run(ctx context.Context, gvks []schema.GroupVersionKind) error {
+	for _, gvk := range gvks {
+		list := &unstructured.UnstructuredList{}
+		list.SetGroupVersionKind(gvk.GroupVersion().WithKind(gvk.Kind + "List"))
+		if err := c.cli.List(ctx, list, client.InNamespace(c.namespace)); err != nil {
+			return fmt.Errorf("error listing %s: %w", gvk.Kind, err)
+		}
+		for i := range list.Items {
+			obj := &list.Items[i]
+			found := c.matchedFinalizers(obj)
+			if len(found) == 0 {
+				continue
+			}
+			fmt.Fprintf(c.out, "%s\t%s\t%s\n", gvk.Kind, client.ObjectKeyFromObject(obj), strings.Join(found, ","))
+			if !c.strip {
+				continue
+			}
+			if err := c.stripFinalizers(ctx, obj); err != nil {
+				return fmt.Errorf("error stripping finalizers of %s %s: %w", gvk.Kind, client.ObjectKeyFromObject(obj), err)
+			}
+		}
+	}
+	return nil
+}
+
+func (c *cleaner) matchedFinalizers(obj client.Object) []string {
+	var found []string
+	for _, f := range obj.GetFinalizers() {
+		if slices.Contains(c.finalizers, f) {
+			found = append(found, f)
+		}
+	}
+	return found
+}
+
+func (c *cleaner) stripFinalizers(ctx context.Context, obj client.Object) error {
+	key := client.ObjectKeyFromObject(obj)
+	refresh := false
+	return retry.RetryOnConflict(retry.DefaultBackoff, func() error {
+		if refresh {
+			if err := c.cli.Get(ctx, key, obj); err != nil {
+				return client.IgnoreNotFound(err)
+			}
+		}
+		refresh = true
+		before := obj.DeepCopyObject().(client.Object)
+		for _, f := range c.finalizers {
+			controllerutil.RemoveFinalizer(obj, f)
+		}
+		return client.IgnoreNotFound(c.cli.Patch(ctx, obj, client.MergeFromWithOptions(before, client.MergeFromWithOptimisticLock{})))
+	})
+}
@@ -0,0 +1,59 @@
+// Copyright 2022 Matrix Origin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	kubefake "sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestCleaner(t *testing.T) {
+	g := NewGomegaWithT(t)
+	held := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{
+		Namespace:  "default",
+		Name:       "held",
+		Finalizers: []string{"matrixorigin.io/test", "other.io/keep"},
+	}}
+	free := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{
+		Namespace:  "default",
+		Name:       "free",
+		Finalizers: []string{"other.io/keep"},
+	}}
+	cli := kubefake.NewClientBuilder().WithObjects(held, free).Build()
+	gvks := []schema.GroupVersionKind{corev1.SchemeGroupVersion.WithKind("ConfigMap")}
+
+	out := &bytes.Buffer{}
+	c := &cleaner{cli: cli, out: out, finalizers: []string{"matrixorigin.io/test"}}
+	g.Expect(c.run(context.Background(), gvks)).To(Succeed())
+	g.Expect(out.String()).To(Equal("ConfigMap\tdefault/held\tmatrixorigin.io/test\n"))
+	got := &corev1.ConfigMap{}
+	g.Expect(cli.Get(context.Background(), client.ObjectKeyFromObject(held), got)).To(Succeed())
+	g.Expect(got.Finalizers).To(ConsistOf("matrixorigin.io/test", "other.io/keep"))
+
+	c.strip = true
+	g.Expect(c.run(context.Background(), gvks)).To(Succeed())
+	g.Expect(cli.Get(context.Background(), client.ObjectKeyFromObject(held), got)).To(Succeed())
+	g.Expect(got.Finalizers).To(ConsistOf("other.io/keep"))
+	g.Expect(cli.Get(context.Background(), client.ObjectKeyFromObject(free), got)).To(Succeed())
+	g.Expect(got.Finalizers).To(ConsistOf("other.io/keep"))
+}
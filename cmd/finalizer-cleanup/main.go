@@ -0,0 +1,120 @@
+// Copyright 2022 Matrix Origin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// finalizer-cleanup lists and optionally strips the finalizers of a reconciler across a cluster,
+// e.g. after the reconciler is renamed or the operator is uninstalled.
+//
+// Usage:
+//
+//	finalizer-cleanup --reconciler cnset --kinds CNSet.v1alpha1.core.matrixorigin.io [--namespace ns] [--strip]
+//
+// Kinds are in the form of Kind.version.group, the group of core kinds is empty and is left after a trailing
+// dot, e.g. ConfigMap.v1.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/matrixorigin/controller-runtime/pkg/reconciler"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/config"
+)
+
+func main() {
+	var (
+		reconcilerName string
+		extra          string
+		kinds          string
+		namespace      string
+		strip          bool
+	)
+	flag.StringVar(&reconcilerName, "reconciler", "", "name of the reconciler whose finalizers should be cleaned up")
+	flag.StringVar(&extra, "finalizers", "", "comma separated extra finalizer names to clean up, e.g. legacy finalizers")
+	flag.StringVar(&kinds, "kinds", "", "comma separated kinds to scan, in the form of Kind.version.group, "+
+		"core kinds end with a dot for the empty group, e.g. ConfigMap.v1.")
+	flag.StringVar(&namespace, "namespace", "", "namespace to scan, all namespaces if empty")
+	flag.BoolVar(&strip, "strip", false, "strip the finalizers, only list them if false")
+	flag.Parse()
+
+	if err := run(reconcilerName, extra, kinds, namespace, strip); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func run(reconcilerName, extra, kinds, namespace string, strip bool) error {
+	var finalizers []string
+	if reconcilerName != "" {
+		finalizers = append(finalizers, reconciler.FinalizerName(reconcilerName))
+	}
+	finalizers = append(finalizers, splitList(extra)...)
+	if len(finalizers) == 0 {
+		return fmt.Errorf("either --reconciler or --finalizers must be specified")
+	}
+	gvks, err := parseKinds(kinds)
+	if err != nil {
+		return err
+	}
+
+	cfg, err := config.GetConfig()
+	if err != nil {
+		return err
+	}
+	cli, err := client.New(cfg, client.Options{})
+	if err != nil {
+		return err
+	}
+	c := &cleaner{
+		cli:        cli,
+		out:        os.Stdout,
+		finalizers: finalizers,
+		namespace:  namespace,
+		strip:      strip,
+	}
+	return c.run(context.Background(), gvks)
+}
+
+// parseKinds parses the comma separated kinds in the form of Kind.version.group
+func parseKinds(kinds string) ([]schema.GroupVersionKind, error) {
+	var gvks []schema.GroupVersionKind
+	for _, k := range splitList(kinds) {
+		gvk, gk := schema.ParseKindArg(k)
+		if gvk == nil {
+			return nil, fmt.Errorf("invalid kind %q, parsed as group %q without a version, expected Kind.version.group, e.g. ConfigMap.v1. for core kinds", k, gk.Group)
+		}
+		if gvk.Version == "" || gvk.Kind == "" {
+			return nil, fmt.Errorf("invalid kind %q, kind and version must not be empty, expected Kind.version.group", k)
+		}
+		gvks = append(gvks, *gvk)
+	}
+	if len(gvks) == 0 {
+		return nil, fmt.Errorf("--kinds must be specified")
+	}
+	return gvks, nil
+}
+
+func splitList(s string) []string {
+	var l []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			l = append(l, v)
+		}
+	}
+	return l
+}
@@ -0,0 +1,42 @@
+// Copyright 2022 Matrix Origin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"testing"
+
+	. "github.com/onsi/gomega"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+func TestParseKinds(t *testing.T) {
+	g := NewGomegaWithT(t)
+	gvks, err := parseKinds("CNSet.v1alpha1.core.matrixorigin.io, ConfigMap.v1.")
+	g.Expect(err).To(Succeed())
+	g.Expect(gvks).To(Equal([]schema.GroupVersionKind{
+		{Group: "core.matrixorigin.io", Version: "v1alpha1", Kind: "CNSet"},
+		{Group: "", Version: "v1", Kind: "ConfigMap"},
+	}))
+
+	// a core kind without the trailing dot parses as group v1
+	_, err = parseKinds("ConfigMap.v1")
+	g.Expect(err).To(MatchError(ContainSubstring("ConfigMap.v1.")))
+	_, err = parseKinds("ConfigMap..apps")
+	g.Expect(err).To(HaveOccurred())
+	_, err = parseKinds("ConfigMap")
+	g.Expect(err).To(HaveOccurred())
+	_, err = parseKinds("")
+	g.Expect(err).To(HaveOccurred())
+}
@@ -21,7 +21,9 @@ import (
 	"runtime"
 
 	"github.com/go-logr/logr"
+	"k8s.io/apimachinery/pkg/api/equality"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	clientretry "k8s.io/client-go/util/retry"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
 )
@@ -33,6 +35,7 @@ type Actor[T client.Object] interface {
 
 type Action[T client.Object] func(*Context[T]) error
 
+// String returns the function name of the action, see NamedAction for a stable name
 func (s Action[T]) String() string {
 	return runtime.FuncForPC(reflect.ValueOf(s).Pointer()).Name()
 }
@@ -59,10 +62,35 @@ type Context[T client.Object] struct {
 	// the object implement the `Dependant` interface
 	Dep T
 
+	// DeletionPolicy is the deletion policy of the object T, will only be set when
+	// the object is being finalized
+	DeletionPolicy DeletionPolicy
+
 	Client client.Client
 	// TODO(aylei): add tracing
 	Event EventEmitter
 	Log   logr.Logger
+
+	// noStatusSubresource indicates the status of T should be updated along with the whole object
+	noStatusSubresource bool
+	// observed is the object as read at the beginning of the reconcile, only kept when noStatusSubresource is set
+	observed client.Object
+	// subActor is the name of the sub-actor of a CompositeActor that is currently working
+	subActor string
+	// actionInfo describes the working action, it is filled in by NamedAction and the built-in gates as the
+	// action executes, named is set once a NamedAction starts
+	actionInfo ActionInfo
+	named      bool
+	// actionFunc is the function name of the action wrapped by the built-in gates
+	actionFunc string
+	// locks are the keys locked by the current reconcile in the lock table of the manager
+	locks  map[string]bool
+	locker *keyedLocker
+	// disruptionBudget limits the concurrent disruptive actions, the object holds it by disruptionKey
+	disruptionBudget *DisruptionBudget
+	disruptionKey    string
+	// asyncOps tracks the running operations of AsyncActions
+	asyncOps *asyncOperations
 }
 
 // TODO(aylei): add logging and tracing when operate upon kube-api
@@ -79,11 +107,54 @@ func (c *Context[T]) Update(obj client.Object, opts ...client.UpdateOption) erro
 	return c.Client.Update(c, obj, opts...)
 }
 
-// UpdateStatus update the status of the given obj
+// UpdateStatus update the status of the given obj, the whole obj will be updated if it is
+// a T that does not have a status subresource
 func (c *Context[T]) UpdateStatus(obj client.Object, opts ...client.SubResourceUpdateOption) error {
+	if _, isT := obj.(T); isT && c.noStatusSubresource {
+		o := &client.SubResourceUpdateOptions{}
+		o.ApplyOptions(opts)
+		return c.Client.Update(c, obj, &o.UpdateOptions)
+	}
 	return c.Client.Status().Update(c, obj, opts...)
 }
 
+// UpdateWithRetry applies mutateFn to the given obj and updates its spec. On conflict, the obj is
+// re-read from the apiserver and mutateFn is re-applied before retrying with a bounded backoff.
+func (c *Context[T]) UpdateWithRetry(obj client.Object, mutateFn func() error, opts ...client.UpdateOption) error {
+	return c.retryOnConflict(obj, mutateFn, func() error {
+		return c.Update(obj, opts...)
+	})
+}
+
+// UpdateStatusWithRetry is the status counterpart of UpdateWithRetry
+func (c *Context[T]) UpdateStatusWithRetry(obj client.Object, mutateFn func() error, opts ...client.SubResourceUpdateOption) error {
+	return c.retryOnConflict(obj, mutateFn, func() error {
+		return c.UpdateStatus(obj, opts...)
+	})
+}
+
+func (c *Context[T]) retryOnConflict(obj client.Object, mutateFn func() error, updateFn func() error) error {
+	key := client.ObjectKeyFromObject(obj)
+	refresh := false
+	return clientretry.RetryOnConflict(clientretry.DefaultBackoff, func() error {
+		if refresh {
+			if err := c.Get(key, obj); err != nil {
+				return err
+			}
+		}
+		refresh = true
+		before := obj.DeepCopyObject()
+		if err := mutate(mutateFn, key, obj); err != nil {
+			return err
+		}
+		if equality.Semantic.DeepEqual(before, obj) {
+			// no change to update
+			return nil
+		}
+		return updateFn()
+	})
+}
+
 // Delete marks the given obj to be deleted
 func (c *Context[T]) Delete(obj client.Object, opts ...client.DeleteOption) error {
 	return c.Client.Delete(c, obj, opts...)
@@ -95,26 +166,34 @@ func (c *Context[T]) List(objList client.ObjectList, opts ...client.ListOption)
 
 // Patch patches the mutation by mutateFn to the spec of given obj
 // an error would be raised if mutateFn changed anything immutable (e.g. namespace / name).
-// Changes will be merged with current object, optimisticLock is enforced.
+// By default, changes will be merged with current object and optimisticLock is enforced, pass
+// a PatchStrategy to choose another kind of patch and a *PatchOutput to receive the computed patch.
 func (c *Context[T]) Patch(obj client.Object, mutateFn func() error, opts ...client.PatchOption) error {
-	patch, err := c.buildPatch(obj, mutateFn)
+	strategy, out := patchSettings(opts)
+	patch, err := c.buildPatch(obj, mutateFn, strategy, out)
 	if patch == nil {
 		return err
 	}
-	return c.Client.Patch(c, obj, *patch, opts...)
+	return c.Client.Patch(c, obj, patch, opts...)
 }
 
 // PatchStatus patches the mutation by mutateFn to the status of given obj
 // an error would be raised if mutateFn changed anything immutable (e.g. namespace / name)
 func (c *Context[T]) PatchStatus(obj client.Object, mutateFn func() error, opts ...client.SubResourcePatchOption) error {
-	patch, err := c.buildPatch(obj, mutateFn)
+	strategy, out := patchSettings(opts)
+	patch, err := c.buildPatch(obj, mutateFn, strategy, out)
 	if patch == nil {
 		return err
 	}
-	return c.Client.Status().Patch(c, obj, *patch, opts...)
+	if _, isT := obj.(T); isT && c.noStatusSubresource {
+		o := &client.SubResourcePatchOptions{}
+		o.ApplyOptions(opts)
+		return c.Client.Patch(c, obj, patch, &o.PatchOptions)
+	}
+	return c.Client.Status().Patch(c, obj, patch, opts...)
 }
 
-func (c *Context[T]) buildPatch(obj client.Object, mutateFn func() error) (*client.Patch, error) {
+func (c *Context[T]) buildPatch(obj client.Object, mutateFn func() error, strategy PatchStrategy, out *PatchOutput) (client.Patch, error) {
 	key := client.ObjectKeyFromObject(obj)
 	before := obj.DeepCopyObject().(client.Object)
 	if err := mutateFn(); err != nil {
@@ -127,8 +206,19 @@ func (c *Context[T]) buildPatch(obj client.Object, mutateFn func() error) (*clie
 		// no change to patch
 		return nil, nil
 	}
-	p := client.MergeFromWithOptions(before, client.MergeFromWithOptimisticLock{})
-	return &p, nil
+	p, err := newPatch(strategy, before, obj)
+	if err != nil {
+		return nil, err
+	}
+	if out != nil {
+		data, err := p.Data(obj)
+		if err != nil {
+			return nil, err
+		}
+		out.Type = p.Type()
+		out.Data = data
+	}
+	return p, nil
 }
 
 // CreateOwned create the given object with an OwnerReference to the currently reconciling
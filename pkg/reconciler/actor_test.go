@@ -0,0 +1,103 @@
+// Copyright 2022 Matrix Origin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reconciler
+
+import (
+	"context"
+	"testing"
+
+	"github.com/go-logr/logr"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	kubefake "sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func newTestContext[T client.Object](obj T, cli client.Client) *Context[T] {
+	return &Context[T]{
+		Context: context.Background(),
+		Obj:     obj,
+		Client:  cli,
+		Log:     logr.Discard(),
+	}
+}
+
+func TestUpdateWithRetry(t *testing.T) {
+	g := NewGomegaWithT(t)
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "test"}}
+	cli := kubefake.NewClientBuilder().WithObjects(pod).Build()
+	ctx := newTestContext(pod, cli)
+
+	stale := &corev1.Pod{}
+	g.Expect(ctx.Get(client.ObjectKeyFromObject(pod), stale)).To(Succeed())
+
+	// bump the resource version so that the stale copy conflicts
+	fresh := stale.DeepCopy()
+	fresh.Labels = map[string]string{"a": "a"}
+	g.Expect(ctx.Update(fresh)).To(Succeed())
+
+	calls := 0
+	g.Expect(ctx.UpdateWithRetry(stale, func() error {
+		calls++
+		if stale.Annotations == nil {
+			stale.Annotations = map[string]string{}
+		}
+		stale.Annotations["b"] = "b"
+		return nil
+	})).To(Succeed())
+	g.Expect(calls).To(Equal(2))
+
+	got := &corev1.Pod{}
+	g.Expect(ctx.Get(client.ObjectKeyFromObject(pod), got)).To(Succeed())
+	g.Expect(got.Labels).To(HaveKeyWithValue("a", "a"))
+	g.Expect(got.Annotations).To(HaveKeyWithValue("b", "b"))
+}
+
+func TestEnsureFinalizerOnConflict(t *testing.T) {
+	g := NewGomegaWithT(t)
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "test"}}
+	cli := kubefake.NewClientBuilder().WithObjects(pod).Build()
+	r := &Reconciler[*corev1.Pod]{options: &options{}, Client: cli, name: "test"}
+
+	stale := &corev1.Pod{}
+	g.Expect(cli.Get(context.Background(), client.ObjectKeyFromObject(pod), stale)).To(Succeed())
+	fresh := stale.DeepCopy()
+	fresh.Labels = map[string]string{"a": "a"}
+	g.Expect(cli.Update(context.Background(), fresh)).To(Succeed())
+
+	ctx := newTestContext(stale, cli)
+	g.Expect(r.ensureFinalizer(ctx, stale)).To(Succeed())
+	g.Expect(r.hasFinalizer(stale)).To(BeTrue())
+
+	g.Expect(r.removeFinalizer(ctx, stale)).To(Succeed())
+	got := &corev1.Pod{}
+	g.Expect(cli.Get(context.Background(), client.ObjectKeyFromObject(pod), got)).To(Succeed())
+	g.Expect(r.hasFinalizer(got)).To(BeFalse())
+}
+
+func TestMigrateLegacyFinalizer(t *testing.T) {
+	g := NewGomegaWithT(t)
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "test", Finalizers: []string{"matrixorigin.io/legacy"}}}
+	cli := kubefake.NewClientBuilder().WithObjects(pod).Build()
+	r := &Reconciler[*corev1.Pod]{options: &options{legacyFinalizers: []string{"matrixorigin.io/legacy"}}, Client: cli, name: "test"}
+	ctx := newTestContext(pod, cli)
+
+	g.Expect(r.hasFinalizer(pod)).To(BeTrue())
+	g.Expect(r.ensureFinalizer(ctx, pod)).To(Succeed())
+	got := &corev1.Pod{}
+	g.Expect(cli.Get(context.Background(), client.ObjectKeyFromObject(pod), got)).To(Succeed())
+	g.Expect(got.Finalizers).To(Equal([]string{"matrixorigin.io/test"}))
+}
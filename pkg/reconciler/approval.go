@@ -0,0 +1,138 @@
+// Copyright 2022 Matrix Origin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reconciler
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// ApprovalAnnotation approves an action that requires approval, the value must be <action>@<spec hash>, e.g.
+// scaleIn@5f1b2c3d4e, so that an approval never applies to another action or a later change of the object.
+// The hash covers everything but the metadata and status of the object, see ApprovalValue.
+const ApprovalAnnotation = "reconcile.matrixorigin.io/approve"
+
+// ConditionTypePendingApproval Whether the object has an action waiting for approval
+const ConditionTypePendingApproval = "PendingApproval"
+
+const (
+	reasonApprovalRequired = "ApprovalRequired"
+	reasonApproved         = "Approved"
+
+	// the approval annotation triggers a reconcile itself, recheck periodically in case the event is missed
+	approvalRecheckInterval = 5 * time.Minute
+	// the length of the spec hash in the approval value
+	approvalHashLength = 10
+)
+
+// RequireApproval marks the action as requiring human approval. The action is recorded with its plan in the
+// PendingApproval condition and is only executed after the object is annotated with ApprovalAnnotation
+// that matches the name of the action and the current spec of the object.
+func RequireApproval[T client.Object](name string, plan string, action Action[T]) Action[T] {
+	return func(ctx *Context[T]) error {
+		ctx.markAction(action, func(info *ActionInfo) { info.ApprovalPlan = plan })
+		if err := ctx.waitApproval(name, plan); err != nil {
+			return err
+		}
+		return action(ctx)
+	}
+}
+
+// ApprovalValue returns the value of ApprovalAnnotation that approves the named action of obj at its current spec.
+// The value is bound to a hash of the spec rather than the generation, which is also bumped by the status updates
+// of an object without a status subresource.
+func ApprovalValue(obj client.Object, action string) (string, error) {
+	u, err := runtime.DefaultUnstructuredConverter.ToUnstructured(obj)
+	if err != nil {
+		return "", err
+	}
+	delete(u, "apiVersion")
+	delete(u, "kind")
+	delete(u, "metadata")
+	delete(u, "status")
+	data, err := json.Marshal(u)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return fmt.Sprintf("%s@%s", action, hex.EncodeToString(sum[:])[:approvalHashLength]), nil
+}
+
+func (c *Context[T]) waitApproval(name string, plan string) error {
+	generation := c.Obj.GetGeneration()
+	value, err := ApprovalValue(c.Obj, name)
+	if err != nil {
+		return err
+	}
+	cond, isConditional := any(c.Obj).(Conditional)
+	var pending *metav1.Condition
+	if isConditional {
+		pending = meta.FindStatusCondition(cond.GetConditions(), ConditionTypePendingApproval)
+	}
+	if c.Obj.GetAnnotations()[ApprovalAnnotation] == value {
+		if pending != nil && pending.Status == metav1.ConditionTrue {
+			msg := fmt.Sprintf("action %s is approved: %s", name, plan)
+			c.Event.EmitEventGeneric(reasonApproved, msg, nil)
+			cond.SetCondition(metav1.Condition{
+				Type:               ConditionTypePendingApproval,
+				Status:             metav1.ConditionFalse,
+				ObservedGeneration: generation,
+				Reason:             reasonApproved,
+				Message:            msg,
+			})
+		}
+		return nil
+	}
+	msg := fmt.Sprintf("action %s requires approval: %s, approve by annotating %s=%s",
+		name, plan, ApprovalAnnotation, value)
+	// emit the request event only once for each pending action, plan and spec
+	if pending == nil || pending.Status != metav1.ConditionTrue || pending.Message != msg {
+		c.Event.EmitEventGeneric(reasonApprovalRequired, msg, nil)
+	}
+	if isConditional {
+		cond.SetCondition(metav1.Condition{
+			Type:               ConditionTypePendingApproval,
+			Status:             metav1.ConditionTrue,
+			ObservedGeneration: generation,
+			Reason:             reasonApprovalRequired,
+			Message:            msg,
+		})
+	}
+	return ErrWait(msg, approvalRecheckInterval)
+}
+
+// clearPendingApproval sets the PendingApproval condition of obj to False if it is pending, which is used when the
+// object is synced, i.e. no action is pending approval anymore
+func clearPendingApproval(obj client.Object) {
+	cond, ok := obj.(Conditional)
+	if !ok || !meta.IsStatusConditionTrue(cond.GetConditions(), ConditionTypePendingApproval) {
+		return
+	}
+	cond.SetCondition(metav1.Condition{
+		Type:               ConditionTypePendingApproval,
+		Status:             metav1.ConditionFalse,
+		ObservedGeneration: obj.GetGeneration(),
+		Reason:             reasonSynced,
+		Message:            "no action is pending approval",
+	})
+}
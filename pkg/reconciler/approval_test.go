@@ -0,0 +1,130 @@
+// Copyright 2022 Matrix Origin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reconciler
+
+import (
+	"context"
+	"testing"
+
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	recon "sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+func TestRequireApproval(t *testing.T) {
+	g := NewGomegaWithT(t)
+	obj := &testObject{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "test", Generation: 3}}
+	recorder := record.NewFakeRecorder(10)
+	ctx := newTestContext(obj, nil)
+	ctx.Event = &EmitEventWrapper{EventRecorder: recorder, subject: obj}
+
+	executed := 0
+	action := RequireApproval("scaleIn", "scale in from 5 to 3 replicas", func(*Context[*testObject]) error {
+		executed++
+		return nil
+	})
+
+	// pending, the request event is emitted only once
+	for i := 0; i < 2; i++ {
+		var resync *ReSync
+		g.Expect(action(ctx)).To(BeAssignableToTypeOf(resync))
+	}
+	g.Expect(executed).To(Equal(0))
+	c := meta.FindStatusCondition(obj.GetConditions(), ConditionTypePendingApproval)
+	g.Expect(c.Status).To(Equal(metav1.ConditionTrue))
+	g.Expect(c.Message).To(ContainSubstring("scale in from 5 to 3 replicas"))
+	g.Expect(recorder.Events).To(HaveLen(1))
+	g.Expect(<-recorder.Events).To(ContainSubstring(reasonApprovalRequired))
+
+	// approval of a stale spec or another action does not count
+	value, err := ApprovalValue(obj, "scaleIn")
+	g.Expect(err).To(Succeed())
+	upgrade, err := ApprovalValue(obj, "upgrade")
+	g.Expect(err).To(Succeed())
+	for _, v := range []string{"scaleIn@3", "scaleIn@0123456789", upgrade, "scaleIn"} {
+		obj.Annotations = map[string]string{ApprovalAnnotation: v}
+		g.Expect(action(ctx)).ToNot(Succeed())
+	}
+	g.Expect(executed).To(Equal(0))
+
+	// the approval is kept across the generations bumped without changing the spec
+	obj.Generation = 4
+	obj.Annotations = map[string]string{ApprovalAnnotation: value}
+	g.Expect(action(ctx)).To(Succeed())
+	g.Expect(executed).To(Equal(1))
+	g.Expect(meta.IsStatusConditionFalse(obj.GetConditions(), ConditionTypePendingApproval)).To(BeTrue())
+	g.Expect(<-recorder.Events).To(ContainSubstring(reasonApproved))
+}
+
+func TestReconcileApprovedAction(t *testing.T) {
+	g := NewGomegaWithT(t)
+	obj := &testObject{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "test", Generation: 2}}
+	executed := 0
+	actor := &testActor{ObserveFn: func(*Context[*testObject]) (Action[*testObject], error) {
+		if executed > 0 {
+			return nil, nil
+		}
+		return RequireApproval("scaleIn", "scale in from 5 to 3 replicas", func(*Context[*testObject]) error {
+			executed++
+			return nil
+		}), nil
+	}}
+	r, cli := newTestReconciler(t, actor, &options{skipFinalizer: true}, obj)
+	req := recon.Request{NamespacedName: client.ObjectKeyFromObject(obj)}
+	got := &testObject{}
+
+	_, err := r.Reconcile(context.Background(), req)
+	g.Expect(err).To(Succeed())
+	g.Expect(cli.Get(context.Background(), req.NamespacedName, got)).To(Succeed())
+	g.Expect(meta.IsStatusConditionTrue(got.GetConditions(), ConditionTypePendingApproval)).To(BeTrue())
+
+	value, err := ApprovalValue(got, "scaleIn")
+	g.Expect(err).To(Succeed())
+	got.Annotations = map[string]string{ApprovalAnnotation: value}
+	g.Expect(cli.Update(context.Background(), got)).To(Succeed())
+	_, err = r.Reconcile(context.Background(), req)
+	g.Expect(err).To(Succeed())
+	g.Expect(executed).To(Equal(1))
+	g.Expect(cli.Get(context.Background(), req.NamespacedName, got)).To(Succeed())
+	g.Expect(meta.FindStatusCondition(got.GetConditions(), ConditionTypePendingApproval).Reason).To(Equal(reasonApproved))
+
+	// synced pass
+	_, err = r.Reconcile(context.Background(), req)
+	g.Expect(err).To(Succeed())
+	g.Expect(cli.Get(context.Background(), req.NamespacedName, got)).To(Succeed())
+	g.Expect(meta.IsStatusConditionTrue(got.GetConditions(), ConditionTypeSynced)).To(BeTrue())
+	g.Expect(meta.IsStatusConditionFalse(got.GetConditions(), ConditionTypePendingApproval)).To(BeTrue())
+}
+
+func TestApprovalValue(t *testing.T) {
+	g := NewGomegaWithT(t)
+	cm := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "test"}, Data: map[string]string{"replicas": "5"}}
+	value, err := ApprovalValue(cm, "scaleIn")
+	g.Expect(err).To(Succeed())
+	g.Expect(value).To(HavePrefix("scaleIn@"))
+
+	// metadata does not change the value, e.g. the approval annotation itself
+	cm.Annotations = map[string]string{ApprovalAnnotation: value}
+	cm.Generation = 2
+	g.Expect(ApprovalValue(cm, "scaleIn")).To(Equal(value))
+
+	// a change of the object does
+	cm.Data["replicas"] = "3"
+	g.Expect(ApprovalValue(cm, "scaleIn")).ToNot(Equal(value))
+}
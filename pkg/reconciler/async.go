@@ -0,0 +1,227 @@
+// Copyright 2022 Matrix Origin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reconciler
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const defaultAsyncPollInterval = 10 * time.Second
+
+// AsyncOperation is a long-running operation started by an AsyncAction
+type AsyncOperation interface {
+	// Poll checks whether the operation is completed, a non-nil error means the operation failed
+	Poll(ctx context.Context) (done bool, err error)
+	// Cancel aborts the operation, it is called when the spec of the object changes or the object is deleted
+	Cancel()
+}
+
+// AsyncOperationStatus records the running AsyncOperation of an object
+// +kubebuilder:object:generate=true
+type AsyncOperationStatus struct {
+	// Name is the name of the AsyncAction that started the operation
+	Name string `json:"name"`
+	// StartTime is the time the operation started
+	StartTime metav1.Time `json:"startTime"`
+	// ObservedGeneration is the generation of the object when the operation started
+	ObservedGeneration int64 `json:"observedGeneration"`
+}
+
+// AsyncOperationRecorder is implemented by objects that record their running AsyncOperation in status,
+// nil is set when the operation completes or is canceled
+type AsyncOperationRecorder interface {
+	SetAsyncOperation(op *AsyncOperationStatus)
+}
+
+// AsyncAction builds a NamedAction that runs a long-running operation without blocking the worker.
+// The first execution calls start and tracks the returned operation in memory, then each following
+// execution polls the operation every pollInterval until it completes. A running operation is canceled
+// when the generation of the object changes or the object is deleted.
+// Operations are not persisted, start should adopt the ongoing operation, if any, after the process restarts.
+// A Terminal error is returned if T does not have a status subresource, see AllowUpdateWithoutStatusSubresource.
+func AsyncAction[T client.Object](name string, start func(*Context[T]) (AsyncOperation, error), pollInterval time.Duration) Action[T] {
+	if pollInterval == 0 {
+		pollInterval = defaultAsyncPollInterval
+	}
+	return NamedAction(ActionInfo{Name: name}, func(ctx *Context[T]) error {
+		if ctx.asyncOps == nil {
+			return fmt.Errorf("async action %s is not executed by a reconciler", name)
+		}
+		if ctx.noStatusSubresource {
+			return ErrTerminal(fmt.Errorf("async action %s requires the status subresource, the operation is keyed on the generation bumped by each status update", name))
+		}
+		key := client.ObjectKeyFromObject(ctx.Obj)
+		// operations started for a former generation or object are canceled by the reconciler before Observe
+		op, running := ctx.asyncOps.get(key, name)
+		if !running {
+			started, err := start(ctx)
+			if err != nil {
+				return err
+			}
+			op = &asyncOperation{AsyncOperation: started, uid: ctx.Obj.GetUID(), generation: ctx.Obj.GetGeneration()}
+			ctx.asyncOps.put(key, name, op)
+			setAsyncOperation(ctx.Obj, &AsyncOperationStatus{
+				Name:               name,
+				StartTime:          metav1.Now(),
+				ObservedGeneration: op.generation,
+			})
+			return ErrReSync(fmt.Sprintf("async operation %s started", name), pollInterval)
+		}
+		done, err := op.Poll(ctx)
+		if !done && err == nil {
+			return ErrReSync(fmt.Sprintf("async operation %s is in progress", name), pollInterval)
+		}
+		ctx.asyncOps.remove(key, name)
+		setAsyncOperation(ctx.Obj, nil)
+		return err
+	})
+}
+
+// GoOperation runs fn in a goroutine as an AsyncOperation, the context passed to fn is canceled on Cancel
+func GoOperation(fn func(ctx context.Context) error) AsyncOperation {
+	ctx, cancel := context.WithCancel(context.Background())
+	op := &goOperation{cancel: cancel, done: make(chan struct{})}
+	go func() {
+		defer close(op.done)
+		op.err = fn(ctx)
+	}()
+	return op
+}
+
+type goOperation struct {
+	cancel context.CancelFunc
+	done   chan struct{}
+	err    error
+}
+
+func (o *goOperation) Poll(context.Context) (bool, error) {
+	select {
+	case <-o.done:
+		return true, o.err
+	default:
+		return false, nil
+	}
+}
+
+func (o *goOperation) Cancel() {
+	o.cancel()
+}
+
+type asyncOperation struct {
+	AsyncOperation
+	uid types.UID
+	// generation is the generation of the object when the operation started
+	generation int64
+}
+
+// asyncOperations tracks the running operations of the objects of a reconciler by object key and action name
+type asyncOperations struct {
+	sync.Mutex
+	ops map[client.ObjectKey]map[string]*asyncOperation
+}
+
+func newAsyncOperations() *asyncOperations {
+	return &asyncOperations{ops: map[client.ObjectKey]map[string]*asyncOperation{}}
+}
+
+func (a *asyncOperations) get(key client.ObjectKey, name string) (*asyncOperation, bool) {
+	a.Lock()
+	defer a.Unlock()
+	op, ok := a.ops[key][name]
+	return op, ok
+}
+
+func (a *asyncOperations) put(key client.ObjectKey, name string, op *asyncOperation) {
+	a.Lock()
+	defer a.Unlock()
+	if a.ops[key] == nil {
+		a.ops[key] = map[string]*asyncOperation{}
+	}
+	a.ops[key][name] = op
+}
+
+func (a *asyncOperations) remove(key client.ObjectKey, name string) *asyncOperation {
+	a.Lock()
+	defer a.Unlock()
+	op := a.ops[key][name]
+	delete(a.ops[key], name)
+	if len(a.ops[key]) == 0 {
+		delete(a.ops, key)
+	}
+	return op
+}
+
+// tracking returns whether any operation of the object is running
+func (a *asyncOperations) tracking(key client.ObjectKey) bool {
+	if a == nil {
+		return false
+	}
+	a.Lock()
+	defer a.Unlock()
+	return len(a.ops[key]) > 0
+}
+
+// cancelStale cancels the running operations of the object that are started for another uid or generation,
+// and returns the names of the canceled operations
+func (a *asyncOperations) cancelStale(key client.ObjectKey, uid types.UID, generation int64) []string {
+	if a == nil {
+		return nil
+	}
+	a.Lock()
+	var names []string
+	var stale []*asyncOperation
+	for name, op := range a.ops[key] {
+		if op.uid != uid || op.generation != generation {
+			names = append(names, name)
+			stale = append(stale, op)
+			delete(a.ops[key], name)
+		}
+	}
+	if len(a.ops[key]) == 0 {
+		delete(a.ops, key)
+	}
+	a.Unlock()
+	for _, op := range stale {
+		op.Cancel()
+	}
+	return names
+}
+
+// cancelAll cancels all the running operations of the object
+func (a *asyncOperations) cancelAll(key client.ObjectKey) {
+	if a == nil {
+		return
+	}
+	a.Lock()
+	ops := a.ops[key]
+	delete(a.ops, key)
+	a.Unlock()
+	for _, op := range ops {
+		op.Cancel()
+	}
+}
+
+func setAsyncOperation(obj client.Object, op *AsyncOperationStatus) {
+	if r, ok := obj.(AsyncOperationRecorder); ok {
+		r.SetAsyncOperation(op)
+	}
+}
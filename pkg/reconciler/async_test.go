@@ -0,0 +1,108 @@
+// Copyright 2022 Matrix Origin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reconciler
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	recon "sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+func TestAsyncAction(t *testing.T) {
+	g := NewGomegaWithT(t)
+	obj := &testObject{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "test", Generation: 1}}
+	release := make(chan struct{})
+	started := 0
+	action := AsyncAction("backup", func(*Context[*testObject]) (AsyncOperation, error) {
+		started++
+		return GoOperation(func(ctx context.Context) error {
+			select {
+			case <-release:
+			case <-ctx.Done():
+			}
+			return ctx.Err()
+		}), nil
+	}, time.Minute)
+	actor := &testActor{ObserveFn: func(*Context[*testObject]) (Action[*testObject], error) {
+		return action, nil
+	}}
+	r, cli := newTestReconciler(t, actor, &options{skipFinalizer: true}, obj)
+	req := recon.Request{NamespacedName: client.ObjectKeyFromObject(obj)}
+	got := &testObject{}
+
+	// the worker is released while the operation is running
+	for i := 0; i < 2; i++ {
+		res, err := r.Reconcile(context.Background(), req)
+		g.Expect(err).To(Succeed())
+		g.Expect(res.RequeueAfter).To(Equal(time.Minute))
+	}
+	g.Expect(started).To(Equal(1))
+	g.Expect(cli.Get(context.Background(), req.NamespacedName, got)).To(Succeed())
+	g.Expect(got.Status.AsyncOperation).ToNot(BeNil())
+	g.Expect(got.Status.AsyncOperation.Name).To(Equal("backup"))
+
+	close(release)
+	g.Eventually(func() time.Duration {
+		res, err := r.Reconcile(context.Background(), req)
+		g.Expect(err).To(Succeed())
+		return res.RequeueAfter
+	}).Should(Equal(retry.RequeueAfter))
+	_, running := r.asyncOps.get(req.NamespacedName, "backup")
+	g.Expect(running).To(BeFalse())
+	g.Expect(cli.Get(context.Background(), req.NamespacedName, got)).To(Succeed())
+	g.Expect(got.Status.AsyncOperation).To(BeNil())
+}
+
+func TestAsyncActionCanceledOnSpecChange(t *testing.T) {
+	g := NewGomegaWithT(t)
+	obj := &testObject{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "test", Generation: 1}}
+	canceled := make(chan struct{})
+	action := AsyncAction("migrate", func(*Context[*testObject]) (AsyncOperation, error) {
+		return GoOperation(func(ctx context.Context) error {
+			<-ctx.Done()
+			close(canceled)
+			return ctx.Err()
+		}), nil
+	}, time.Minute)
+	actor := &testActor{ObserveFn: func(ctx *Context[*testObject]) (Action[*testObject], error) {
+		// the new spec does not need the operation anymore
+		if ctx.Obj.Generation > 1 {
+			return nil, nil
+		}
+		return action, nil
+	}}
+	r, cli := newTestReconciler(t, actor, &options{skipFinalizer: true}, obj)
+	req := recon.Request{NamespacedName: client.ObjectKeyFromObject(obj)}
+
+	_, err := r.Reconcile(context.Background(), req)
+	g.Expect(err).To(Succeed())
+
+	got := &testObject{}
+	g.Expect(cli.Get(context.Background(), req.NamespacedName, got)).To(Succeed())
+	got.Generation = 2
+	g.Expect(cli.Update(context.Background(), got)).To(Succeed())
+	_, err = r.Reconcile(context.Background(), req)
+	g.Expect(err).To(Succeed())
+	g.Eventually(canceled).Should(BeClosed())
+	_, running := r.asyncOps.get(req.NamespacedName, "migrate")
+	g.Expect(running).To(BeFalse())
+	g.Expect(cli.Get(context.Background(), req.NamespacedName, got)).To(Succeed())
+	g.Expect(got.Status.AsyncOperation).To(BeNil())
+}
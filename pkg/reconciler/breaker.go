@@ -0,0 +1,198 @@
+// Copyright 2022 Matrix Origin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reconciler
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/go-errors/errors"
+	kerr "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	recon "sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+const (
+	reasonCircuitOpen = "CircuitOpen"
+
+	defaultCircuitThreshold = 5
+	defaultCircuitCoolDown  = time.Minute
+)
+
+// CircuitBreakerOptions configures the circuit breaker of a reconciler
+type CircuitBreakerOptions struct {
+	// Threshold is the number of consecutive failed actions that opens the circuit, defaults to 5
+	Threshold int
+	// CoolDown is how long actions are short-circuited once the circuit is open, after which a single probe
+	// action is allowed, the circuit is closed if the probe succeeds and re-opened otherwise. Defaults to 1m.
+	CoolDown time.Duration
+	// PerObject counts the failures of each object separately instead of across all the objects of the reconciler.
+	// In the default global mode, the failures of any objects add up and an open circuit suspends the actions of
+	// every object of the reconciler, which fits failures of a shared dependency, e.g. an external system that is
+	// down. Set PerObject if the failures are specific to objects, so that one failing object does not block others.
+	PerObject bool
+}
+
+// WithCircuitBreaker short-circuits the actions of the reconciler after consecutive failures, e.g. to stop
+// hammering an external system that is down. ReSync and conflict errors count as neither failures nor successes.
+func WithCircuitBreaker(opts CircuitBreakerOptions) ApplyOption {
+	return func(o *options) { o.circuitBreaker = &opts }
+}
+
+type circuitBreaker struct {
+	sync.Mutex
+	CircuitBreakerOptions
+	circuits map[string]*circuit
+}
+
+type circuit struct {
+	failures int
+	// openUntil is the end of the cool-down if the circuit is open
+	openUntil time.Time
+	// probeUntil is set when a probe is in flight in the half-open state, another probe is allowed after
+	// it expires in case the result of the probe is never recorded
+	probeUntil time.Time
+}
+
+func newCircuitBreaker(opts CircuitBreakerOptions) *circuitBreaker {
+	if opts.Threshold < 1 {
+		opts.Threshold = defaultCircuitThreshold
+	}
+	if opts.CoolDown == 0 {
+		opts.CoolDown = defaultCircuitCoolDown
+	}
+	return &circuitBreaker{CircuitBreakerOptions: opts, circuits: map[string]*circuit{}}
+}
+
+// allow returns whether an action can be executed, otherwise how long to wait for the next attempt.
+// probe is true if the caller is allowed as the probe of the half-open circuit, the caller must release
+// the probe in case the result of the action is not recorded.
+func (b *circuitBreaker) allow(key string, now time.Time) (allowed bool, wait time.Duration, probe bool) {
+	b.Lock()
+	defer b.Unlock()
+	c, ok := b.circuits[key]
+	if !ok || c.openUntil.IsZero() {
+		return true, 0, false
+	}
+	if now.Before(c.openUntil) {
+		return false, c.openUntil.Sub(now), false
+	}
+	if now.Before(c.probeUntil) {
+		return false, c.probeUntil.Sub(now), false
+	}
+	// half-open, let the caller probe
+	c.probeUntil = now.Add(b.CoolDown)
+	return true, 0, true
+}
+
+// releaseProbe allows another probe of the half-open circuit, it is a no-op if the result of the probe
+// has been recorded
+func (b *circuitBreaker) releaseProbe(key string) {
+	b.Lock()
+	defer b.Unlock()
+	if c, ok := b.circuits[key]; ok {
+		c.probeUntil = time.Time{}
+	}
+}
+
+// record records the result of an action and returns whether the circuit is open afterwards
+func (b *circuitBreaker) record(key string, failed bool, now time.Time) bool {
+	b.Lock()
+	defer b.Unlock()
+	c, ok := b.circuits[key]
+	if !failed {
+		delete(b.circuits, key)
+		return false
+	}
+	if !ok {
+		c = &circuit{}
+		b.circuits[key] = c
+	}
+	c.failures++
+	// a failed probe re-opens the circuit immediately
+	if c.failures >= b.Threshold || !c.openUntil.IsZero() {
+		c.openUntil = now.Add(b.CoolDown)
+		c.probeUntil = time.Time{}
+	}
+	return !c.openUntil.IsZero()
+}
+
+func (b *circuitBreaker) failures(key string) int {
+	b.Lock()
+	defer b.Unlock()
+	if c, ok := b.circuits[key]; ok {
+		return c.failures
+	}
+	return 0
+}
+
+func (b *circuitBreaker) open() int {
+	b.Lock()
+	defer b.Unlock()
+	n := 0
+	for _, c := range b.circuits {
+		if !c.openUntil.IsZero() {
+			n++
+		}
+	}
+	return n
+}
+
+func (r *Reconciler[T]) breakerKey(key client.ObjectKey) string {
+	if r.breaker.PerObject {
+		return key.String()
+	}
+	return ""
+}
+
+// shortCircuit skips the action of the object since the circuit is open
+func (r *Reconciler[T]) shortCircuit(ctx *Context[T], wait time.Duration) (recon.Result, error) {
+	objKey := client.ObjectKeyFromObject(ctx.Obj)
+	key := r.breakerKey(objKey)
+	msg := fmt.Sprintf("circuit breaker is open after %d consecutive failures, actions are suspended for %s",
+		r.breaker.failures(key), wait.Round(time.Second))
+	ctx.Log.V(Debug).Info("short-circuit action", "wait", wait)
+	shortCircuitedTotal.WithLabelValues(r.name).Inc()
+	r.trySetCondition(ctx.Obj, metav1.Condition{
+		Type:               ConditionTypeSynced,
+		Status:             metav1.ConditionFalse,
+		ObservedGeneration: ctx.Obj.GetGeneration(),
+		Reason:             reasonCircuitOpen,
+		Message:            msg,
+	})
+	setNextRetry(ctx.Obj, time.Now().Add(wait), r.failures.count(objKey))
+	if err := r.updateStatus(ctx); err != nil && !kerr.IsConflict(err) {
+		return backoff, errors.Wrap(err, 0)
+	}
+	return recon.Result{RequeueAfter: wait}, nil
+}
+
+// recordActionResult feeds the result of an action to the circuit breaker, ReSync and conflict errors
+// prove nothing about the health of the action and are not recorded
+func (r *Reconciler[T]) recordActionResult(ctx *Context[T], actionErr error) {
+	if r.breaker == nil {
+		return
+	}
+	var resync *ReSync
+	if errors.As(actionErr, &resync) || kerr.IsConflict(actionErr) {
+		return
+	}
+	if r.breaker.record(r.breakerKey(client.ObjectKeyFromObject(ctx.Obj)), actionErr != nil, time.Now()) {
+		ctx.Log.Info("circuit breaker is open", "coolDown", r.breaker.CoolDown)
+	}
+	openCircuits.WithLabelValues(r.name).Set(float64(r.breaker.open()))
+}
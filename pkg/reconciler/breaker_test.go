@@ -0,0 +1,122 @@
+// Copyright 2022 Matrix Origin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reconciler
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	recon "sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	g := NewGomegaWithT(t)
+	b := newCircuitBreaker(CircuitBreakerOptions{Threshold: 2, CoolDown: time.Minute})
+	now := time.Now()
+
+	g.Expect(b.record("", true, now)).To(BeFalse())
+	g.Expect(b.record("", true, now)).To(BeTrue())
+	allowed, wait, _ := b.allow("", now.Add(10*time.Second))
+	g.Expect(allowed).To(BeFalse())
+	g.Expect(wait).To(Equal(50 * time.Second))
+
+	// half-open, only a single probe is allowed
+	allowed, _, probe := b.allow("", now.Add(time.Minute))
+	g.Expect(allowed).To(BeTrue())
+	g.Expect(probe).To(BeTrue())
+	allowed, _, _ = b.allow("", now.Add(time.Minute))
+	g.Expect(allowed).To(BeFalse())
+
+	// a probe that is released without a result lets another one probe
+	b.releaseProbe("")
+	allowed, _, probe = b.allow("", now.Add(time.Minute))
+	g.Expect(allowed).To(BeTrue())
+	g.Expect(probe).To(BeTrue())
+
+	// a failed probe re-opens the circuit
+	g.Expect(b.record("", true, now.Add(time.Minute))).To(BeTrue())
+	allowed, _, _ = b.allow("", now.Add(90*time.Second))
+	g.Expect(allowed).To(BeFalse())
+
+	// a successful probe closes the circuit
+	allowed, _, _ = b.allow("", now.Add(2*time.Minute))
+	g.Expect(allowed).To(BeTrue())
+	g.Expect(b.record("", false, now.Add(2*time.Minute))).To(BeFalse())
+	allowed, _, _ = b.allow("", now.Add(2*time.Minute))
+	g.Expect(allowed).To(BeTrue())
+	g.Expect(b.open()).To(BeZero())
+
+	// the threshold defaults to 5
+	b = newCircuitBreaker(CircuitBreakerOptions{})
+	for i := 0; i < 4; i++ {
+		g.Expect(b.record("", true, now)).To(BeFalse())
+	}
+	g.Expect(b.record("", true, now)).To(BeTrue())
+}
+
+func TestReconcileCircuitBreaker(t *testing.T) {
+	g := NewGomegaWithT(t)
+	obj := &testObject{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "test"}}
+	executed := 0
+	var actionErr error = fmt.Errorf("external system is down")
+	actor := &testActor{ObserveFn: func(*Context[*testObject]) (Action[*testObject], error) {
+		return func(*Context[*testObject]) error {
+			executed++
+			return actionErr
+		}, nil
+	}}
+	r, cli := newTestReconciler(t, actor, &options{skipFinalizer: true}, obj)
+	r.breaker = newCircuitBreaker(CircuitBreakerOptions{Threshold: 2, CoolDown: time.Minute})
+	req := recon.Request{NamespacedName: client.ObjectKeyFromObject(obj)}
+
+	for i := 0; i < 2; i++ {
+		_, err := r.Reconcile(context.Background(), req)
+		g.Expect(err).To(HaveOccurred())
+	}
+	res, err := r.Reconcile(context.Background(), req)
+	g.Expect(err).To(Succeed())
+	g.Expect(res.RequeueAfter).To(BeNumerically("~", time.Minute, time.Second))
+	g.Expect(executed).To(Equal(2))
+
+	got := &testObject{}
+	g.Expect(cli.Get(context.Background(), req.NamespacedName, got)).To(Succeed())
+	c := meta.FindStatusCondition(got.GetConditions(), ConditionTypeSynced)
+	g.Expect(c.Reason).To(Equal(reasonCircuitOpen))
+	g.Expect(c.Message).To(ContainSubstring("2 consecutive failures"))
+	g.Expect(got.Status.NextRetryTime).ToNot(BeNil())
+	g.Expect(got.Status.NextRetryTime.Time).To(BeTemporally("~", time.Now().Add(time.Minute), 2*time.Second))
+
+	// a probe requesting resync proves nothing, the circuit is kept open and the next pass probes again
+	key := r.breakerKey(req.NamespacedName)
+	r.breaker.circuits[key].openUntil = time.Now()
+	actionErr = ErrReSync("external system is starting")
+	for i := 0; i < 2; i++ {
+		_, err = r.Reconcile(context.Background(), req)
+		g.Expect(err).To(Succeed())
+	}
+	g.Expect(executed).To(Equal(4))
+	g.Expect(r.breaker.open()).To(Equal(1))
+
+	actionErr = nil
+	_, err = r.Reconcile(context.Background(), req)
+	g.Expect(err).To(Succeed())
+	g.Expect(r.breaker.open()).To(BeZero())
+}
@@ -0,0 +1,81 @@
+// Copyright 2022 Matrix Origin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reconciler
+
+import (
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// SubActor is a named Actor that reconciles one aspect of T in a CompositeActor
+type SubActor[T client.Object] struct {
+	Name  string
+	Actor Actor[T]
+}
+
+// CompositeActor composes a chain of SubActors into one Actor:
+// Observe runs the sub-actors in order and returns the first non-nil action,
+// Finalize runs the sub-actors in reverse order and completes only if all of them are done.
+// The sub-actor that produces the action is recorded in logs, metrics and the Synced condition.
+type CompositeActor[T client.Object] struct {
+	actors []SubActor[T]
+}
+
+var _ Actor[client.Object] = &CompositeActor[client.Object]{}
+
+func NewCompositeActor[T client.Object](actors ...SubActor[T]) *CompositeActor[T] {
+	return &CompositeActor[T]{actors: actors}
+}
+
+func (c *CompositeActor[T]) Observe(ctx *Context[T]) (Action[T], error) {
+	parent := ctx.subActor
+	for _, a := range c.actors {
+		ctx.subActor = joinActorName(parent, a.Name)
+		action, err := a.Actor.Observe(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if action != nil {
+			ctx.Log.V(Debug).Info("sub-actor requests action", "actor", ctx.subActor)
+			return action, nil
+		}
+	}
+	ctx.subActor = parent
+	return nil, nil
+}
+
+func (c *CompositeActor[T]) Finalize(ctx *Context[T]) (bool, error) {
+	parent := ctx.subActor
+	for i := len(c.actors) - 1; i >= 0; i-- {
+		a := c.actors[i]
+		ctx.subActor = joinActorName(parent, a.Name)
+		done, err := a.Actor.Finalize(ctx)
+		if err != nil {
+			return false, err
+		}
+		if !done {
+			ctx.Log.V(Debug).Info("sub-actor does not complete finalizing", "actor", ctx.subActor)
+			return false, nil
+		}
+	}
+	ctx.subActor = parent
+	return true, nil
+}
+
+func joinActorName(parent string, name string) string {
+	if parent == "" {
+		return name
+	}
+	return parent + "/" + name
+}
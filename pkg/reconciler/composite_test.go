@@ -0,0 +1,65 @@
+// Copyright 2022 Matrix Origin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reconciler
+
+import (
+	"context"
+	"testing"
+
+	. "github.com/onsi/gomega"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	recon "sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+func TestCompositeActor(t *testing.T) {
+	g := NewGomegaWithT(t)
+	var observed, finalized []string
+	sub := func(name string, action Action[*testObject], done bool) SubActor[*testObject] {
+		return SubActor[*testObject]{Name: name, Actor: &testActor{
+			ObserveFn: func(*Context[*testObject]) (Action[*testObject], error) {
+				observed = append(observed, name)
+				return action, nil
+			},
+			FinalizeFn: func(*Context[*testObject]) (bool, error) {
+				finalized = append(finalized, name)
+				return done, nil
+			},
+		}}
+	}
+	noop := func(*Context[*testObject]) error { return nil }
+	actor := NewCompositeActor(
+		sub("config", nil, true),
+		sub("service", noop, false),
+		sub("statefulset", noop, true),
+	)
+	obj := &testObject{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "test"}}
+	r, cli := newTestReconciler(t, actor, &options{skipFinalizer: true}, obj)
+
+	_, err := r.Reconcile(context.Background(), recon.Request{NamespacedName: client.ObjectKeyFromObject(obj)})
+	g.Expect(err).To(Succeed())
+	g.Expect(observed).To(Equal([]string{"config", "service"}))
+	got := &testObject{}
+	g.Expect(cli.Get(context.Background(), client.ObjectKeyFromObject(obj), got)).To(Succeed())
+	synced := meta.FindStatusCondition(got.GetConditions(), ConditionTypeSynced)
+	g.Expect(synced).ToNot(BeNil())
+	g.Expect(synced.Message).To(ContainSubstring("service"))
+
+	done, err := actor.Finalize(newTestContext(obj, cli))
+	g.Expect(err).To(Succeed())
+	g.Expect(done).To(BeFalse())
+	g.Expect(finalized).To(Equal([]string{"statefulset", "service"}))
+}
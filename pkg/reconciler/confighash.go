@@ -0,0 +1,219 @@
+// Copyright 2022 Matrix Origin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reconciler
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"hash"
+	"sort"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// ConfigHashAnnotation is stamped on pod templates with the content hash of the referenced ConfigMaps and Secrets,
+// so that the pods are rolled when any of them changes
+const ConfigHashAnnotation = "reconcile.matrixorigin.io/configHash"
+
+const (
+	configMapRef = "ConfigMap"
+	secretRef    = "Secret"
+)
+
+type configRef struct {
+	kind string
+	name string
+}
+
+type configHashOptions struct {
+	skip map[configRef]bool
+}
+
+type ConfigHashOption func(*configHashOptions)
+
+// SkipConfigMap excludes the ConfigMap with the given name from the config hash
+func SkipConfigMap(name string) ConfigHashOption {
+	return func(o *configHashOptions) { o.skip[configRef{kind: configMapRef, name: name}] = true }
+}
+
+// SkipSecret excludes the Secret with the given name from the config hash
+func SkipSecret(name string) ConfigHashOption {
+	return func(o *configHashOptions) { o.skip[configRef{kind: secretRef, name: name}] = true }
+}
+
+// StampConfigHash computes the content hash of all the ConfigMaps and Secrets referenced by the pod template
+// (volumes, envFrom and env valueFrom) in the given namespace and stamps it as the ConfigHashAnnotation of
+// the template
+func StampConfigHash(kubeCli KubeClient, namespace string, tpl *corev1.PodTemplateSpec, opts ...ConfigHashOption) error {
+	hash, err := ConfigHash(kubeCli, namespace, &tpl.Spec, opts...)
+	if err != nil {
+		return err
+	}
+	if tpl.Annotations == nil {
+		tpl.Annotations = map[string]string{}
+	}
+	tpl.Annotations[ConfigHashAnnotation] = hash
+	return nil
+}
+
+// ConfigHash computes the content hash of all the ConfigMaps and Secrets referenced by the pod spec
+func ConfigHash(kubeCli KubeClient, namespace string, spec *corev1.PodSpec, opts ...ConfigHashOption) (string, error) {
+	o := &configHashOptions{skip: map[configRef]bool{}}
+	for _, opt := range opts {
+		opt(o)
+	}
+	refs := map[configRef]bool{}
+	collectPodSpecRefs(spec, refs)
+	var sorted []configRef
+	for ref := range refs {
+		if !o.skip[ref] {
+			sorted = append(sorted, ref)
+		}
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].kind != sorted[j].kind {
+			return sorted[i].kind < sorted[j].kind
+		}
+		return sorted[i].name < sorted[j].name
+	})
+
+	h := sha256.New()
+	for _, ref := range sorted {
+		data, err := configData(kubeCli, namespace, ref)
+		if err != nil {
+			if apierrors.IsNotFound(err) && refs[ref] {
+				// optional reference that does not exist
+				continue
+			}
+			return "", err
+		}
+		writeField(h, ref.kind)
+		writeField(h, ref.name)
+		keys := make([]string, 0, len(data))
+		for k := range data {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			writeField(h, k)
+			writeField(h, string(data[k]))
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// collectPodSpecRefs collects the ConfigMaps and Secrets referenced by the pod spec
+func collectPodSpecRefs(spec *corev1.PodSpec, refs map[configRef]bool) {
+	for _, v := range spec.Volumes {
+		collectVolumeRefs(v.VolumeSource, refs)
+	}
+	for _, c := range spec.InitContainers {
+		collectContainerRefs(c, refs)
+	}
+	for _, c := range spec.Containers {
+		collectContainerRefs(c, refs)
+	}
+}
+
+// addRef records the reference, a reference is optional only if all its usages are optional
+func addRef(refs map[configRef]bool, kind string, name string, optional *bool) {
+	ref := configRef{kind: kind, name: name}
+	opt := optional != nil && *optional
+	if existing, ok := refs[ref]; ok {
+		refs[ref] = existing && opt
+		return
+	}
+	refs[ref] = opt
+}
+
+func collectVolumeRefs(v corev1.VolumeSource, refs map[configRef]bool) {
+	if v.ConfigMap != nil {
+		addRef(refs, configMapRef, v.ConfigMap.Name, v.ConfigMap.Optional)
+	}
+	if v.Secret != nil {
+		addRef(refs, secretRef, v.Secret.SecretName, v.Secret.Optional)
+	}
+	if v.Projected != nil {
+		for _, s := range v.Projected.Sources {
+			if s.ConfigMap != nil {
+				addRef(refs, configMapRef, s.ConfigMap.Name, s.ConfigMap.Optional)
+			}
+			if s.Secret != nil {
+				addRef(refs, secretRef, s.Secret.Name, s.Secret.Optional)
+			}
+		}
+	}
+}
+
+func collectContainerRefs(c corev1.Container, refs map[configRef]bool) {
+	for _, from := range c.EnvFrom {
+		if from.ConfigMapRef != nil {
+			addRef(refs, configMapRef, from.ConfigMapRef.Name, from.ConfigMapRef.Optional)
+		}
+		if from.SecretRef != nil {
+			addRef(refs, secretRef, from.SecretRef.Name, from.SecretRef.Optional)
+		}
+	}
+	for _, env := range c.Env {
+		if env.ValueFrom == nil {
+			continue
+		}
+		if ref := env.ValueFrom.ConfigMapKeyRef; ref != nil {
+			addRef(refs, configMapRef, ref.Name, ref.Optional)
+		}
+		if ref := env.ValueFrom.SecretKeyRef; ref != nil {
+			addRef(refs, secretRef, ref.Name, ref.Optional)
+		}
+	}
+}
+
+func configData(kubeCli KubeClient, namespace string, ref configRef) (map[string][]byte, error) {
+	key := client.ObjectKey{Namespace: namespace, Name: ref.name}
+	data := map[string][]byte{}
+	switch ref.kind {
+	case configMapRef:
+		cm := &corev1.ConfigMap{}
+		if err := kubeCli.Get(key, cm); err != nil {
+			return nil, err
+		}
+		for k, v := range cm.Data {
+			data[k] = []byte(v)
+		}
+		for k, v := range cm.BinaryData {
+			data[k] = v
+		}
+	case secretRef:
+		s := &corev1.Secret{}
+		if err := kubeCli.Get(key, s); err != nil {
+			return nil, err
+		}
+		for k, v := range s.Data {
+			data[k] = v
+		}
+		for k, v := range s.StringData {
+			data[k] = []byte(v)
+		}
+	}
+	return data, nil
+}
+
+// writeField writes a length-prefixed field to the hash to avoid ambiguity between adjacent fields
+func writeField(h hash.Hash, s string) {
+	_ = binary.Write(h, binary.LittleEndian, uint64(len(s)))
+	_, _ = h.Write([]byte(s))
+}
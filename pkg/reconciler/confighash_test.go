@@ -0,0 +1,70 @@
+// Copyright 2022 Matrix Origin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reconciler
+
+import (
+	"testing"
+
+	"github.com/matrixorigin/controller-runtime/pkg/util"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kubefake "sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestStampConfigHash(t *testing.T) {
+	g := NewGomegaWithT(t)
+	cm := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "config"}, Data: map[string]string{"a": "a"}}
+	secret := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "secret"}, Data: map[string][]byte{"p": []byte("p")}}
+	cli := kubefake.NewClientBuilder().WithObjects(cm, secret).Build()
+	ctx := newTestContext(cm, cli)
+	optional := true
+	tpl := &corev1.PodTemplateSpec{Spec: corev1.PodSpec{
+		Volumes: []corev1.Volume{{Name: "config", VolumeSource: util.ConfigMapVolume("config")}},
+		Containers: []corev1.Container{{
+			Name: "main",
+			Env: []corev1.EnvVar{{Name: "P", ValueFrom: &corev1.EnvVarSource{
+				SecretKeyRef: &corev1.SecretKeySelector{LocalObjectReference: corev1.LocalObjectReference{Name: "secret"}, Key: "p"},
+			}}},
+			EnvFrom: []corev1.EnvFromSource{{
+				ConfigMapRef: &corev1.ConfigMapEnvSource{LocalObjectReference: corev1.LocalObjectReference{Name: "missing"}, Optional: &optional},
+			}},
+		}},
+	}}
+
+	g.Expect(StampConfigHash(ctx, "default", tpl)).To(Succeed())
+	origin := tpl.Annotations[ConfigHashAnnotation]
+	g.Expect(origin).ToNot(BeEmpty())
+
+	// stable if nothing changes
+	g.Expect(StampConfigHash(ctx, "default", tpl)).To(Succeed())
+	g.Expect(tpl.Annotations[ConfigHashAnnotation]).To(Equal(origin))
+
+	secret.Data["p"] = []byte("q")
+	g.Expect(ctx.Update(secret)).To(Succeed())
+	g.Expect(StampConfigHash(ctx, "default", tpl)).To(Succeed())
+	secretChanged := tpl.Annotations[ConfigHashAnnotation]
+	g.Expect(secretChanged).ToNot(Equal(origin))
+
+	// changes of the skipped ConfigMap do not affect the hash
+	g.Expect(StampConfigHash(ctx, "default", tpl, SkipConfigMap("config"))).To(Succeed())
+	skipped := tpl.Annotations[ConfigHashAnnotation]
+	cm.Data["a"] = "b"
+	g.Expect(ctx.Update(cm)).To(Succeed())
+	g.Expect(StampConfigHash(ctx, "default", tpl, SkipConfigMap("config"))).To(Succeed())
+	g.Expect(tpl.Annotations[ConfigHashAnnotation]).To(Equal(skipped))
+	g.Expect(StampConfigHash(ctx, "default", tpl)).To(Succeed())
+	g.Expect(tpl.Annotations[ConfigHashAnnotation]).ToNot(Equal(secretChanged))
+}
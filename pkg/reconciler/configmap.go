@@ -0,0 +1,177 @@
+// Copyright 2022 Matrix Origin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reconciler
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// VersionedConfigMapLabel labels the versioned ConfigMaps with their base name
+const VersionedConfigMapLabel = "reconcile.matrixorigin.io/configMap"
+
+const versionHashLength = 10
+
+// CreateVersionedConfigMap creates an immutable ConfigMap owned by ctx.Obj, whose name is the name of the given cm
+// suffixed with the hash of its content. The generated name is returned to be referenced by pod templates,
+// e.g. by util.ConfigMapVolume. Changing the content creates a new version instead of mutating the existing one,
+// so that rollouts are atomic.
+func CreateVersionedConfigMap[T client.Object](ctx *Context[T], cm *corev1.ConfigMap) (string, error) {
+	base := cm.Name
+	versioned := cm.DeepCopy()
+	versioned.Name = fmt.Sprintf("%s-%s", base, configMapContentHash(cm)[:versionHashLength])
+	versioned.Namespace = ctx.Obj.GetNamespace()
+	if versioned.Labels == nil {
+		versioned.Labels = map[string]string{}
+	}
+	versioned.Labels[VersionedConfigMapLabel] = base
+	immutable := true
+	versioned.Immutable = &immutable
+	if err := ctx.CreateOwned(versioned); err != nil && !apierrors.IsAlreadyExists(err) {
+		return "", err
+	}
+	return versioned.Name, nil
+}
+
+// GCVersionedConfigMaps deletes the versioned ConfigMaps of the given base name owned by ctx.Obj that are
+// no longer referenced by any live pod or any pod template of the owned workloads, the latest retention
+// unreferenced versions are kept. Workloads defaults to StatefulSets and Deployments, any list of
+// objects with a pod template at spec.template is supported.
+// GC should be called after the pod templates have been updated to refer to the latest version.
+func GCVersionedConfigMaps[T client.Object](ctx *Context[T], base string, retention int, workloads ...client.ObjectList) error {
+	owner := ctx.Obj
+	cms := &corev1.ConfigMapList{}
+	if err := ctx.List(cms, client.InNamespace(owner.GetNamespace()), client.MatchingLabels{VersionedConfigMapLabel: base}); err != nil {
+		return err
+	}
+	referenced, err := referencedConfigMaps(ctx, workloads)
+	if err != nil {
+		return err
+	}
+	var unreferenced []corev1.ConfigMap
+	for _, cm := range cms.Items {
+		if isOwnedBy(&cm, owner.GetUID()) && !referenced[cm.Name] {
+			unreferenced = append(unreferenced, cm)
+		}
+	}
+	// newest first
+	sort.Slice(unreferenced, func(i, j int) bool {
+		ti, tj := unreferenced[i].CreationTimestamp, unreferenced[j].CreationTimestamp
+		if !ti.Equal(&tj) {
+			return tj.Before(&ti)
+		}
+		return unreferenced[i].Name > unreferenced[j].Name
+	})
+	for i := retention; i < len(unreferenced); i++ {
+		if err := ctx.Delete(&unreferenced[i]); err != nil && !apierrors.IsNotFound(err) {
+			return err
+		}
+		ctx.Log.Info("garbage collect versioned configmap", "name", unreferenced[i].Name)
+	}
+	return nil
+}
+
+// referencedConfigMaps collects the names of ConfigMaps referenced by pods and the owned workloads in the namespace
+func referencedConfigMaps[T client.Object](ctx *Context[T], workloads []client.ObjectList) (map[string]bool, error) {
+	if len(workloads) == 0 {
+		workloads = []client.ObjectList{&appsv1.StatefulSetList{}, &appsv1.DeploymentList{}}
+	}
+	ns := ctx.Obj.GetNamespace()
+	refs := map[configRef]bool{}
+	pods := &corev1.PodList{}
+	if err := ctx.List(pods, client.InNamespace(ns)); err != nil {
+		return nil, err
+	}
+	for i := range pods.Items {
+		collectPodSpecRefs(&pods.Items[i].Spec, refs)
+	}
+	for _, w := range workloads {
+		list := w.DeepCopyObject().(client.ObjectList)
+		if err := ctx.List(list, client.InNamespace(ns)); err != nil {
+			return nil, err
+		}
+		items, err := meta.ExtractList(list)
+		if err != nil {
+			return nil, err
+		}
+		for _, item := range items {
+			if !isOwnedBy(item.(client.Object), ctx.Obj.GetUID()) {
+				continue
+			}
+			tpl, err := podTemplateOf(item)
+			if err != nil {
+				return nil, err
+			}
+			if tpl != nil {
+				collectPodSpecRefs(&tpl.Spec, refs)
+			}
+		}
+	}
+	names := map[string]bool{}
+	for ref := range refs {
+		if ref.kind == configMapRef {
+			names[ref.name] = true
+		}
+	}
+	return names, nil
+}
+
+// podTemplateOf extracts the pod template at spec.template of the given object, nil is returned if there is none
+func podTemplateOf(obj runtime.Object) (*corev1.PodTemplateSpec, error) {
+	u, err := runtime.DefaultUnstructuredConverter.ToUnstructured(obj)
+	if err != nil {
+		return nil, err
+	}
+	m, found, err := unstructured.NestedMap(u, "spec", "template")
+	if err != nil || !found {
+		return nil, err
+	}
+	tpl := &corev1.PodTemplateSpec{}
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(m, tpl); err != nil {
+		return nil, err
+	}
+	return tpl, nil
+}
+
+func configMapContentHash(cm *corev1.ConfigMap) string {
+	data := map[string][]byte{}
+	for k, v := range cm.Data {
+		data[k] = []byte(v)
+	}
+	for k, v := range cm.BinaryData {
+		data[k] = v
+	}
+	keys := make([]string, 0, len(data))
+	for k := range data {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	h := sha256.New()
+	for _, k := range keys {
+		writeField(h, k)
+		writeField(h, string(data[k]))
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
@@ -0,0 +1,73 @@
+// Copyright 2022 Matrix Origin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reconciler
+
+import (
+	"testing"
+
+	"github.com/matrixorigin/controller-runtime/pkg/util"
+	. "github.com/onsi/gomega"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	kubefake "sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestVersionedConfigMap(t *testing.T) {
+	g := NewGomegaWithT(t)
+	obj := &testObject{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "test", UID: "test-uid"}}
+	cli := kubefake.NewClientBuilder().WithScheme(newTestScheme()).WithObjects(obj).Build()
+	ctx := newTestContext(obj, cli)
+
+	create := func(v string) string {
+		name, err := CreateVersionedConfigMap(ctx, &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: "config"},
+			Data:       map[string]string{"config.toml": v},
+		})
+		g.Expect(err).To(Succeed())
+		return name
+	}
+	v1 := create("v1")
+	g.Expect(create("v1")).To(Equal(v1))
+	v2 := create("v2")
+	v3 := create("v3")
+	g.Expect(v2).ToNot(Equal(v1))
+
+	got := &corev1.ConfigMap{}
+	g.Expect(ctx.Get(client.ObjectKey{Namespace: "default", Name: v1}, got)).To(Succeed())
+	g.Expect(*got.Immutable).To(BeTrue())
+	g.Expect(isOwnedBy(got, obj.UID)).To(BeTrue())
+
+	sts := &appsv1.StatefulSet{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "sts"},
+		Spec: appsv1.StatefulSetSpec{Template: corev1.PodTemplateSpec{Spec: corev1.PodSpec{
+			Volumes: []corev1.Volume{{Name: "config", VolumeSource: util.ConfigMapVolume(v3)}},
+		}}},
+	}
+	g.Expect(ctx.CreateOwned(sts)).To(Succeed())
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "sts-0"},
+		Spec: corev1.PodSpec{
+			Volumes: []corev1.Volume{{Name: "config", VolumeSource: util.ConfigMapVolume(v1)}},
+		},
+	}
+	g.Expect(ctx.Create(pod)).To(Succeed())
+
+	g.Expect(GCVersionedConfigMaps(ctx, "config", 0)).To(Succeed())
+	g.Expect(ctx.Exist(client.ObjectKey{Namespace: "default", Name: v1}, &corev1.ConfigMap{})).To(BeTrue())
+	g.Expect(ctx.Exist(client.ObjectKey{Namespace: "default", Name: v2}, &corev1.ConfigMap{})).To(BeFalse())
+	g.Expect(ctx.Exist(client.ObjectKey{Namespace: "default", Name: v3}, &corev1.ConfigMap{})).To(BeTrue())
+}
@@ -0,0 +1,157 @@
+// Copyright 2022 Matrix Origin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reconciler
+
+import (
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+)
+
+// DeletionPolicyAnnotation declares the DeletionPolicy of an object that does not implement DeletionPolicySource
+const DeletionPolicyAnnotation = "reconcile.matrixorigin.io/deletionPolicy"
+
+// DeletionPolicy decides what happens to the resources managed by an object when the object is deleted
+type DeletionPolicy string
+
+const (
+	// DeletionPolicyDelete deletes all the managed resources, owned objects are garbage collected by kubernetes.
+	// This is the default policy.
+	DeletionPolicyDelete DeletionPolicy = "Delete"
+	// DeletionPolicyOrphan keeps the owned objects, the reconciler removes the owner references
+	// to the deleted object from them before removing its finalizer. The owned types must be declared by
+	// WithOwnedTypes, otherwise the object is not finalized and keeps the finalizer.
+	DeletionPolicyOrphan DeletionPolicy = "Orphan"
+	// DeletionPolicyRetain keeps the data of the object (e.g. volumes, backups) while the owned objects are
+	// garbage collected, what should be retained is up to the Actor
+	DeletionPolicyRetain DeletionPolicy = "Retain"
+)
+
+// DeletionPolicySource is implemented by objects that declare their DeletionPolicy in spec
+type DeletionPolicySource interface {
+	GetDeletionPolicy() DeletionPolicy
+}
+
+// WithOwnedTypes declares the types of objects that are owned by T, owner references of these objects
+// will be removed when T is deleted with DeletionPolicyOrphan, which is refused if no owned type is declared
+func WithOwnedTypes(objs ...client.Object) ApplyOption {
+	return func(o *options) { o.ownedTypes = append(o.ownedTypes, objs...) }
+}
+
+// GetDeletionPolicy returns the DeletionPolicy of the given object, the policy declared by DeletionPolicySource
+// takes precedence over the DeletionPolicyAnnotation
+func GetDeletionPolicy(obj client.Object) DeletionPolicy {
+	if s, ok := obj.(DeletionPolicySource); ok {
+		if p := s.GetDeletionPolicy(); p != "" {
+			return p
+		}
+	}
+	if p := obj.GetAnnotations()[DeletionPolicyAnnotation]; p != "" {
+		return DeletionPolicy(p)
+	}
+	return DeletionPolicyDelete
+}
+
+func (p DeletionPolicy) validate() error {
+	switch p {
+	case DeletionPolicyDelete, DeletionPolicyOrphan, DeletionPolicyRetain:
+		return nil
+	}
+	return fmt.Errorf("unknown deletion policy %q", p)
+}
+
+// validatePolicy checks whether the reconciler is able to carry out the policy, the owned objects would be
+// garbage collected instead of orphaned if their types are unknown
+func (r *Reconciler[T]) validatePolicy(p DeletionPolicy) error {
+	if err := p.validate(); err != nil {
+		return err
+	}
+	if p == DeletionPolicyOrphan && len(r.ownedListKinds) == 0 {
+		return fmt.Errorf("deletion policy %s requires the owned types declared by WithOwnedTypes", p)
+	}
+	return nil
+}
+
+func (r *Reconciler[T]) setupOwnedTypes(scheme *runtime.Scheme) error {
+	for _, o := range r.ownedTypes {
+		gvk, err := apiutil.GVKForObject(o, scheme)
+		if err != nil {
+			return err
+		}
+		listGVK := gvk.GroupVersion().WithKind(gvk.Kind + "List")
+		if !scheme.Recognizes(listGVK) {
+			return fmt.Errorf("list kind %s of owned type %T is not registered in the scheme", listGVK, o)
+		}
+		r.ownedListKinds = append(r.ownedListKinds, listGVK)
+	}
+	return nil
+}
+
+// orphanOwnedObjects removes the owner references to ctx.Obj from all the owned objects
+func (r *Reconciler[T]) orphanOwnedObjects(ctx *Context[T]) error {
+	owner := ctx.Obj
+	for _, gvk := range r.ownedListKinds {
+		v, err := ctx.Client.Scheme().New(gvk)
+		if err != nil {
+			return err
+		}
+		list := v.(client.ObjectList)
+		if err := ctx.List(list, client.InNamespace(owner.GetNamespace())); err != nil {
+			return err
+		}
+		items, err := meta.ExtractList(list)
+		if err != nil {
+			return err
+		}
+		for _, item := range items {
+			o := item.(client.Object)
+			if !isOwnedBy(o, owner.GetUID()) {
+				continue
+			}
+			if err := ctx.UpdateWithRetry(o, func() error {
+				o.SetOwnerReferences(removeOwnerReference(o.GetOwnerReferences(), owner.GetUID()))
+				return nil
+			}); err != nil {
+				return err
+			}
+			ctx.Log.Info("orphan owned object", "kind", gvk.Kind, "name", o.GetName())
+		}
+	}
+	return nil
+}
+
+func isOwnedBy(obj client.Object, uid types.UID) bool {
+	for _, ref := range obj.GetOwnerReferences() {
+		if ref.UID == uid {
+			return true
+		}
+	}
+	return false
+}
+
+func removeOwnerReference(refs []metav1.OwnerReference, uid types.UID) []metav1.OwnerReference {
+	var kept []metav1.OwnerReference
+	for _, ref := range refs {
+		if ref.UID != uid {
+			kept = append(kept, ref)
+		}
+	}
+	return kept
+}
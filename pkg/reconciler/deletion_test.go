@@ -0,0 +1,116 @@
+// Copyright 2022 Matrix Origin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reconciler
+
+import (
+	"context"
+	"testing"
+
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	recon "sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+func TestFinalizeWithDeletionPolicy(t *testing.T) {
+	tests := []struct {
+		name       string
+		policy     string
+		wantPolicy DeletionPolicy
+		wantOwned  bool
+	}{{
+		name:       "default",
+		wantPolicy: DeletionPolicyDelete,
+		wantOwned:  true,
+	}, {
+		name:       "orphan",
+		policy:     string(DeletionPolicyOrphan),
+		wantPolicy: DeletionPolicyOrphan,
+		wantOwned:  false,
+	}, {
+		name:       "retain",
+		policy:     string(DeletionPolicyRetain),
+		wantPolicy: DeletionPolicyRetain,
+		wantOwned:  true,
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewGomegaWithT(t)
+			now := metav1.Now()
+			obj := &testObject{ObjectMeta: metav1.ObjectMeta{
+				Namespace:         "default",
+				Name:              "test",
+				UID:               "test-uid",
+				Finalizers:        []string{"matrixorigin.io/test"},
+				DeletionTimestamp: &now,
+			}}
+			if tt.policy != "" {
+				obj.Annotations = map[string]string{DeletionPolicyAnnotation: tt.policy}
+			}
+			cm := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{
+				Namespace: "default",
+				Name:      "owned",
+				OwnerReferences: []metav1.OwnerReference{{
+					APIVersion: testGroupVersion.String(),
+					Kind:       "testObject",
+					Name:       "test",
+					UID:        "test-uid",
+				}},
+			}}
+			var got DeletionPolicy
+			actor := &testActor{FinalizeFn: func(ctx *Context[*testObject]) (bool, error) {
+				got = ctx.DeletionPolicy
+				return true, nil
+			}}
+			r, cli := newTestReconciler(t, actor, &options{ownedTypes: []client.Object{&corev1.ConfigMap{}}}, obj, cm)
+
+			_, err := r.Reconcile(context.Background(), recon.Request{NamespacedName: client.ObjectKeyFromObject(obj)})
+			g.Expect(err).To(Succeed())
+			g.Expect(got).To(Equal(tt.wantPolicy))
+
+			g.Expect(cli.Get(context.Background(), client.ObjectKeyFromObject(cm), cm)).To(Succeed())
+			g.Expect(isOwnedBy(cm, obj.UID)).To(Equal(tt.wantOwned))
+		})
+	}
+}
+
+func TestFinalizeOrphanWithoutOwnedTypes(t *testing.T) {
+	g := NewGomegaWithT(t)
+	now := metav1.Now()
+	obj := &testObject{ObjectMeta: metav1.ObjectMeta{
+		Namespace:         "default",
+		Name:              "test",
+		UID:               "test-uid",
+		Annotations:       map[string]string{DeletionPolicyAnnotation: string(DeletionPolicyOrphan)},
+		Finalizers:        []string{"matrixorigin.io/test"},
+		DeletionTimestamp: &now,
+	}}
+	finalized := false
+	actor := &testActor{FinalizeFn: func(ctx *Context[*testObject]) (bool, error) {
+		finalized = true
+		return true, nil
+	}}
+	r, cli := newTestReconciler(t, actor, &options{}, obj)
+
+	// the owned objects cannot be orphaned, keep the finalizer so that they are not garbage collected
+	_, err := r.Reconcile(context.Background(), recon.Request{NamespacedName: client.ObjectKeyFromObject(obj)})
+	g.Expect(err).To(Succeed())
+	g.Expect(finalized).To(BeFalse())
+	g.Expect(cli.Get(context.Background(), client.ObjectKeyFromObject(obj), obj)).To(Succeed())
+	g.Expect(obj.Finalizers).To(ContainElement("matrixorigin.io/test"))
+	g.Expect(<-r.recorder.(*record.FakeRecorder).Events).To(ContainSubstring("WithOwnedTypes"))
+}
@@ -0,0 +1,164 @@
+// Copyright 2022 Matrix Origin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reconciler
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// ConditionTypeWaiting Whether the object is waiting for some resource (e.g. disruption budget) to proceed
+const ConditionTypeWaiting = "Waiting"
+
+const (
+	reasonDisruptionBudget = "DisruptionBudgetExhausted"
+
+	defaultDisruptionRetryInterval = 30 * time.Second
+)
+
+// DisruptionBudget limits the number of objects undergoing disruptive actions (e.g. restart, upgrade) at the same
+// time. The same budget can be shared by all the reconcilers in a manager via WithDisruptionBudget.
+// An object holds the budget from its first disruptive action until it becomes synced or is deleted.
+// The budget is kept in memory and starts empty when the process restarts.
+type DisruptionBudget struct {
+	sync.Mutex
+	// Max is the max number of objects undergoing disruption, 0 means unlimited
+	Max int
+	// MaxPerNamespace is the max number of objects undergoing disruption in each namespace, 0 means unlimited
+	MaxPerNamespace int
+	// RetryInterval is the interval to retry a deferred disruptive action, defaults to 30s
+	RetryInterval time.Duration
+
+	holders      map[string]string
+	perNamespace map[string]int
+}
+
+func NewDisruptionBudget(max int, maxPerNamespace int) *DisruptionBudget {
+	return &DisruptionBudget{
+		Max:             max,
+		MaxPerNamespace: maxPerNamespace,
+		RetryInterval:   defaultDisruptionRetryInterval,
+	}
+}
+
+// WithDisruptionBudget makes the Disruptive actions of the reconciler consume the given budget
+func WithDisruptionBudget(b *DisruptionBudget) ApplyOption {
+	return func(o *options) { o.disruptionBudget = b }
+}
+
+// Disruptive marks the action as disruptive, which will be deferred if the disruption budget is exhausted
+func Disruptive[T client.Object](action Action[T]) Action[T] {
+	return func(ctx *Context[T]) error {
+		ctx.markAction(action, func(info *ActionInfo) { info.Disruptive = true })
+		if err := ctx.acquireDisruption(); err != nil {
+			return err
+		}
+		return action(ctx)
+	}
+}
+
+func (b *DisruptionBudget) acquire(key string, namespace string) bool {
+	b.Lock()
+	defer b.Unlock()
+	if b.holders == nil {
+		b.holders = map[string]string{}
+		b.perNamespace = map[string]int{}
+	}
+	if _, ok := b.holders[key]; ok {
+		return true
+	}
+	if b.Max > 0 && len(b.holders) >= b.Max {
+		return false
+	}
+	if b.MaxPerNamespace > 0 && b.perNamespace[namespace] >= b.MaxPerNamespace {
+		return false
+	}
+	b.holders[key] = namespace
+	b.perNamespace[namespace]++
+	return true
+}
+
+func (b *DisruptionBudget) release(key string) {
+	b.Lock()
+	defer b.Unlock()
+	ns, ok := b.holders[key]
+	if !ok {
+		return
+	}
+	delete(b.holders, key)
+	if b.perNamespace[ns]--; b.perNamespace[ns] <= 0 {
+		delete(b.perNamespace, ns)
+	}
+}
+
+// InUse returns the number of objects holding the budget
+func (b *DisruptionBudget) InUse() int {
+	b.Lock()
+	defer b.Unlock()
+	return len(b.holders)
+}
+
+func (c *Context[T]) acquireDisruption() error {
+	if c.disruptionBudget == nil {
+		return nil
+	}
+	cond, isConditional := any(c.Obj).(Conditional)
+	if !c.disruptionBudget.acquire(c.disruptionKey, c.Obj.GetNamespace()) {
+		msg := "disruption budget exhausted, waiting for other objects to complete disruptive actions"
+		if isConditional {
+			cond.SetCondition(metav1.Condition{
+				Type:               ConditionTypeWaiting,
+				Status:             metav1.ConditionTrue,
+				ObservedGeneration: c.Obj.GetGeneration(),
+				Reason:             reasonDisruptionBudget,
+				Message:            msg,
+			})
+		}
+		interval := c.disruptionBudget.RetryInterval
+		if interval == 0 {
+			interval = defaultDisruptionRetryInterval
+		}
+		return ErrWait(msg, interval)
+	}
+	clearWaiting(c.Obj, reasonDisruptionBudget, "disruption budget acquired")
+	return nil
+}
+
+// clearWaiting sets the Waiting condition of obj to False if it is waiting for the given reason
+func clearWaiting(obj client.Object, reason string, msg string) {
+	cond, ok := obj.(Conditional)
+	if !ok {
+		return
+	}
+	if w := meta.FindStatusCondition(cond.GetConditions(), ConditionTypeWaiting); w != nil &&
+		w.Status == metav1.ConditionTrue && w.Reason == reason {
+		cond.SetCondition(metav1.Condition{
+			Type:               ConditionTypeWaiting,
+			Status:             metav1.ConditionFalse,
+			ObservedGeneration: obj.GetGeneration(),
+			Reason:             reason,
+			Message:            msg,
+		})
+	}
+}
+
+func disruptionKey(kind string, key client.ObjectKey) string {
+	return fmt.Sprintf("%s/%s", kind, key)
+}
@@ -0,0 +1,95 @@
+// Copyright 2022 Matrix Origin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reconciler
+
+import (
+	"context"
+	"testing"
+
+	. "github.com/onsi/gomega"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	recon "sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+func TestDisruptionBudget(t *testing.T) {
+	g := NewGomegaWithT(t)
+	a := &testObject{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "a"}}
+	b := &testObject{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "b"}}
+	synced := map[string]bool{}
+	executed := map[string]int{}
+	actor := &testActor{ObserveFn: func(ctx *Context[*testObject]) (Action[*testObject], error) {
+		if synced[ctx.Obj.Name] {
+			return nil, nil
+		}
+		return Disruptive(func(ctx *Context[*testObject]) error {
+			executed[ctx.Obj.Name]++
+			return nil
+		}), nil
+	}}
+	budget := NewDisruptionBudget(1, 0)
+	r, cli := newTestReconciler(t, actor, &options{skipFinalizer: true, disruptionBudget: budget}, a, b)
+	reconcile := func(obj client.Object) recon.Result {
+		res, err := r.Reconcile(context.Background(), recon.Request{NamespacedName: client.ObjectKeyFromObject(obj)})
+		g.Expect(err).To(Succeed())
+		return res
+	}
+
+	reconcile(a)
+	g.Expect(executed["a"]).To(Equal(1))
+	g.Expect(budget.InUse()).To(Equal(1))
+
+	res := reconcile(b)
+	g.Expect(executed["b"]).To(Equal(0))
+	g.Expect(res.RequeueAfter).To(Equal(defaultDisruptionRetryInterval))
+	got := &testObject{}
+	g.Expect(cli.Get(context.Background(), client.ObjectKeyFromObject(b), got)).To(Succeed())
+	g.Expect(meta.IsStatusConditionTrue(got.GetConditions(), ConditionTypeWaiting)).To(BeTrue())
+
+	// the budget is released once a is synced
+	synced["a"] = true
+	reconcile(a)
+	g.Expect(budget.InUse()).To(Equal(0))
+	reconcile(b)
+	g.Expect(executed["b"]).To(Equal(1))
+
+	// the wait is cleared once the object is synced, even if it never acquires the budget
+	c := &testObject{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "c"}}
+	g.Expect(cli.Create(context.Background(), c)).To(Succeed())
+	reconcile(c)
+	g.Expect(cli.Get(context.Background(), client.ObjectKeyFromObject(c), got)).To(Succeed())
+	g.Expect(meta.IsStatusConditionTrue(got.GetConditions(), ConditionTypeWaiting)).To(BeTrue())
+	synced["c"] = true
+	reconcile(c)
+	g.Expect(cli.Get(context.Background(), client.ObjectKeyFromObject(c), got)).To(Succeed())
+	g.Expect(meta.IsStatusConditionFalse(got.GetConditions(), ConditionTypeWaiting)).To(BeTrue())
+
+	synced["b"] = true
+	reconcile(b)
+	g.Expect(cli.Get(context.Background(), client.ObjectKeyFromObject(b), got)).To(Succeed())
+	g.Expect(meta.IsStatusConditionFalse(got.GetConditions(), ConditionTypeWaiting)).To(BeTrue())
+}
+
+func TestDisruptionBudgetPerNamespace(t *testing.T) {
+	g := NewGomegaWithT(t)
+	budget := NewDisruptionBudget(0, 1)
+	g.Expect(budget.acquire("a", "ns1")).To(BeTrue())
+	g.Expect(budget.acquire("a", "ns1")).To(BeTrue())
+	g.Expect(budget.acquire("b", "ns1")).To(BeFalse())
+	g.Expect(budget.acquire("c", "ns2")).To(BeTrue())
+	budget.release("a")
+	g.Expect(budget.acquire("b", "ns1")).To(BeTrue())
+}
@@ -22,6 +22,9 @@ import (
 type ReSync struct {
 	Message      string
 	RequeueAfter time.Duration
+	// Wait marks a deliberate wait, e.g. for a maintenance window or an approval, which does not count
+	// toward the progress deadline
+	Wait bool
 }
 
 func (e *ReSync) Error() string {
@@ -38,6 +41,31 @@ func ErrReSync(msg string, requeueAfter ...time.Duration) *ReSync {
 	return e
 }
 
+// ErrWait requests a resync after requeueAfter for a deliberate wait, see ReSync.Wait
+// A poll of an in-flight operation, e.g. a running Job, should use ErrReSync so that a hung operation stalls.
+func ErrWait(msg string, requeueAfter time.Duration) *ReSync {
+	return &ReSync{Message: msg, RequeueAfter: requeueAfter, Wait: true}
+}
+
+// Terminal is an error that will not recover without intervention, e.g. an invalid spec. Unlike other
+// errors, which are considered transient, a Terminal error marks the object as stalled in kstatus conditions.
+type Terminal struct {
+	Err error
+}
+
+func (e *Terminal) Error() string {
+	return e.Err.Error()
+}
+
+func (e *Terminal) Unwrap() error {
+	return e.Err
+}
+
+// ErrTerminal marks err as a Terminal error
+func ErrTerminal(err error) *Terminal {
+	return &Terminal{Err: err}
+}
+
 // see: https://go.dev/doc/faq#nil_error
 func IsNil(object interface{}) bool {
 	if object == nil {
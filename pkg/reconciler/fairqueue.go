@@ -0,0 +1,389 @@
+// Copyright 2022 Matrix Origin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reconciler
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	kerr "k8s.io/apimachinery/pkg/api/errors"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/util/uuid"
+	"k8s.io/client-go/util/workqueue"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	recon "sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	"github.com/matrixorigin/controller-runtime/pkg/util"
+)
+
+// FairQueueOptions tunes the fair queue of a reconciler
+type FairQueueOptions struct {
+	// MaxConcurrentPerKey limits the concurrent reconciles of the objects sharing the same key, defaults to 1.
+	// The total concurrency is still limited by the MaxConcurrentReconciles of the controller options.
+	MaxConcurrentPerKey int
+}
+
+type fairQueueOptions struct {
+	FairQueueOptions
+	keyFn func(client.Object) string
+}
+
+// WithFairQueue makes the reconciler process objects in a fair queue instead of the FIFO workqueue of the
+// controller, which round-robins between the keys of the objects so that a key with lots of objects cannot
+// starve others. The key is the namespace of the object if keyFn is nil. Deletions are processed before updates.
+// Only reconcilers built by Setup are supported.
+func WithFairQueue[T client.Object](keyFn func(T) string, opts FairQueueOptions) ApplyOption {
+	return func(o *options) {
+		o.fairQueue = &fairQueueOptions{FairQueueOptions: opts}
+		if keyFn != nil {
+			o.fairQueue.keyFn = func(obj client.Object) string {
+				return keyFn(obj.(T))
+			}
+		}
+	}
+}
+
+// setupFairQueue makes the controller funnel requests into the fair queue, which is drained by the workers
+// run by the manager
+func (r *Reconciler[T]) setupFairQueue(mgr ctrl.Manager) error {
+	if r.fairQueue == nil {
+		return nil
+	}
+	maxPerKey := r.fairQueue.MaxConcurrentPerKey
+	if maxPerKey < 1 {
+		maxPerKey = 1
+	}
+	// retries are rate-limited by the fair queue, the funnel uses an independent rate limiter
+	r.queue = newFairQueue(r.ctrlOpts.RateLimiter, maxPerKey, r.classify)
+	r.ctrlOpts.RateLimiter = workqueue.DefaultControllerRateLimiter()
+	recoverPanic := r.ctrlOpts.RecoverPanic
+	if recoverPanic == nil {
+		recoverPanic = mgr.GetControllerOptions().RecoverPanic
+	}
+	r.recoverPanic = recoverPanic != nil && *recoverPanic
+	return mgr.Add(manager.RunnableFunc(r.runFairQueue))
+}
+
+// classify returns the fair queue key of the request and whether it is a deletion
+func (r *Reconciler[T]) classify(req recon.Request) (string, bool) {
+	obj := r.newT()
+	if err := r.Get(context.Background(), req.NamespacedName, obj); err != nil {
+		return req.Namespace, kerr.IsNotFound(err)
+	}
+	key := obj.GetNamespace()
+	if r.fairQueue.keyFn != nil {
+		key = r.fairQueue.keyFn(obj)
+	}
+	return key, util.WasDeleted(obj)
+}
+
+func (r *Reconciler[T]) runFairQueue(ctx context.Context) error {
+	workers := r.ctrlOpts.MaxConcurrentReconciles
+	if workers < 1 {
+		workers = 1
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for r.processNextFair(ctx) {
+			}
+		}()
+	}
+	<-ctx.Done()
+	r.queue.ShutDown()
+	wg.Wait()
+	return nil
+}
+
+// processNextFair reconciles the next request and requeues it according to the result like the controller does,
+// including the reconcile metrics of the fair queue, the logger and reconcileID of the context and the recovery
+// of panics
+func (r *Reconciler[T]) processNextFair(ctx context.Context) bool {
+	req, shutdown := r.queue.Get()
+	if shutdown {
+		return false
+	}
+	defer r.queue.Done(req)
+	start := time.Now()
+	defer func() {
+		fairReconcileTime.WithLabelValues(r.name).Observe(time.Since(start).Seconds())
+	}()
+
+	log := r.logger.WithValues("namespace", req.Namespace, "name", req.Name, "reconcileID", uuid.NewUUID())
+	ctx = logf.IntoContext(ctx, log)
+	res, err := r.reconcileFair(ctx, req)
+	switch {
+	case err != nil:
+		r.queue.AddRateLimited(req)
+		fairReconcileErrors.WithLabelValues(r.name).Inc()
+		fairReconcileTotal.WithLabelValues(r.name, "error").Inc()
+		log.Error(err, "Reconciler error")
+	case res.RequeueAfter > 0:
+		r.queue.Forget(req)
+		r.queue.AddAfter(req, res.RequeueAfter)
+		fairReconcileTotal.WithLabelValues(r.name, "requeue_after").Inc()
+	case res.Requeue:
+		r.queue.AddRateLimited(req)
+		fairReconcileTotal.WithLabelValues(r.name, "requeue").Inc()
+	default:
+		r.queue.Forget(req)
+		fairReconcileTotal.WithLabelValues(r.name, "success").Inc()
+	}
+	return true
+}
+
+// reconcileFair reconciles the request and recovers the panic if RecoverPanic is set, otherwise the panic
+// is logged and re-raised like the controller does
+func (r *Reconciler[T]) reconcileFair(ctx context.Context, req recon.Request) (_ recon.Result, err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			if r.recoverPanic {
+				for _, fn := range utilruntime.PanicHandlers {
+					fn(p)
+				}
+				err = fmt.Errorf("panic: %v [recovered]", p)
+				return
+			}
+			logf.FromContext(ctx).Info(fmt.Sprintf("Observed a panic in reconciler: %v", p))
+			panic(p)
+		}
+	}()
+	return r.reconcile(ctx, req)
+}
+
+// fairQueue is a work queue that round-robins between keys with a concurrency limit per key, prioritized
+// requests (deletions) are served first. Like the workqueue, a request is never processed concurrently, and a
+// request added while being processed is requeued when it is done.
+type fairQueue struct {
+	sync.Mutex
+	cond      *sync.Cond
+	limiter   workqueue.RateLimiter
+	maxPerKey int
+	classify  func(recon.Request) (key string, priority bool)
+
+	prioritized []recon.Request
+	perKey      map[string][]recon.Request
+	// keys is the round-robin ring of keys with pending requests, next is the index of the next key to serve
+	keys []string
+	next int
+
+	queued     map[recon.Request]*fairItem
+	processing map[recon.Request]string
+	dirty      map[recon.Request]bool
+	inflight   map[string]int
+	// timers are the pending AddAfter, which are stopped on shutdown
+	timers map[*time.Timer]struct{}
+
+	shuttingDown bool
+}
+
+type fairItem struct {
+	key      string
+	priority bool
+}
+
+func newFairQueue(limiter workqueue.RateLimiter, maxPerKey int, classify func(recon.Request) (string, bool)) *fairQueue {
+	q := &fairQueue{
+		limiter:    limiter,
+		maxPerKey:  maxPerKey,
+		classify:   classify,
+		perKey:     map[string][]recon.Request{},
+		queued:     map[recon.Request]*fairItem{},
+		processing: map[recon.Request]string{},
+		dirty:      map[recon.Request]bool{},
+		inflight:   map[string]int{},
+		timers:     map[*time.Timer]struct{}{},
+	}
+	q.cond = sync.NewCond(q)
+	return q
+}
+
+func (q *fairQueue) Add(req recon.Request) {
+	key, priority := q.classify(req)
+	q.Lock()
+	defer q.Unlock()
+	if q.shuttingDown {
+		return
+	}
+	if _, ok := q.processing[req]; ok {
+		q.dirty[req] = true
+		return
+	}
+	if it, ok := q.queued[req]; ok {
+		// a request becomes prioritized once the object is being deleted
+		if priority && !it.priority {
+			q.removeFromKey(req, it.key)
+			it.priority = true
+			q.prioritized = append(q.prioritized, req)
+			q.cond.Signal()
+		}
+		return
+	}
+	q.queued[req] = &fairItem{key: key, priority: priority}
+	if priority {
+		q.prioritized = append(q.prioritized, req)
+	} else {
+		if _, ok := q.perKey[key]; !ok {
+			q.keys = append(q.keys, key)
+		}
+		q.perKey[key] = append(q.perKey[key], req)
+	}
+	q.cond.Signal()
+}
+
+func (q *fairQueue) AddAfter(req recon.Request, d time.Duration) {
+	if d <= 0 {
+		q.Add(req)
+		return
+	}
+	q.Lock()
+	defer q.Unlock()
+	if q.shuttingDown {
+		return
+	}
+	var t *time.Timer
+	t = time.AfterFunc(d, func() {
+		q.Lock()
+		delete(q.timers, t)
+		q.Unlock()
+		q.Add(req)
+	})
+	q.timers[t] = struct{}{}
+}
+
+func (q *fairQueue) AddRateLimited(req recon.Request) {
+	q.AddAfter(req, q.limiter.When(req))
+}
+
+func (q *fairQueue) Forget(req recon.Request) {
+	q.limiter.Forget(req)
+}
+
+// Get blocks until a request whose key is under the concurrency limit is available or the queue is shut down
+func (q *fairQueue) Get() (recon.Request, bool) {
+	q.Lock()
+	defer q.Unlock()
+	for {
+		if q.shuttingDown {
+			return recon.Request{}, true
+		}
+		if req, ok := q.pop(); ok {
+			key := q.queued[req].key
+			delete(q.queued, req)
+			q.processing[req] = key
+			q.inflight[key]++
+			return req, false
+		}
+		q.cond.Wait()
+	}
+}
+
+func (q *fairQueue) Done(req recon.Request) {
+	q.Lock()
+	key := q.processing[req]
+	delete(q.processing, req)
+	if q.inflight[key]--; q.inflight[key] <= 0 {
+		delete(q.inflight, key)
+	}
+	requeue := q.dirty[req]
+	delete(q.dirty, req)
+	// a slot of the key is released
+	q.cond.Broadcast()
+	q.Unlock()
+	if requeue {
+		q.Add(req)
+	}
+}
+
+func (q *fairQueue) ShutDown() {
+	q.Lock()
+	defer q.Unlock()
+	q.shuttingDown = true
+	for t := range q.timers {
+		t.Stop()
+	}
+	q.timers = map[*time.Timer]struct{}{}
+	q.cond.Broadcast()
+}
+
+func (q *fairQueue) Len() int {
+	q.Lock()
+	defer q.Unlock()
+	return len(q.queued)
+}
+
+// pop takes the next request to process, must be called with the lock held
+func (q *fairQueue) pop() (recon.Request, bool) {
+	for i, req := range q.prioritized {
+		if q.inflight[q.queued[req].key] < q.maxPerKey {
+			q.prioritized = append(q.prioritized[:i], q.prioritized[i+1:]...)
+			return req, true
+		}
+	}
+	for n := 0; n < len(q.keys); n++ {
+		i := (q.next + n) % len(q.keys)
+		key := q.keys[i]
+		if q.inflight[key] >= q.maxPerKey {
+			continue
+		}
+		req := q.perKey[key][0]
+		q.removeFromKey(req, key)
+		// serve the key after this one next time, removeFromKey already shifted the ring if the key is drained
+		if _, ok := q.perKey[key]; ok {
+			q.next = i + 1
+		} else {
+			q.next = i
+		}
+		if len(q.keys) > 0 {
+			q.next %= len(q.keys)
+		} else {
+			q.next = 0
+		}
+		return req, true
+	}
+	return recon.Request{}, false
+}
+
+// removeFromKey removes the request from the pending requests of the key, must be called with the lock held
+func (q *fairQueue) removeFromKey(req recon.Request, key string) {
+	reqs := q.perKey[key]
+	for i := range reqs {
+		if reqs[i] == req {
+			reqs = append(reqs[:i], reqs[i+1:]...)
+			break
+		}
+	}
+	if len(reqs) > 0 {
+		q.perKey[key] = reqs
+		return
+	}
+	delete(q.perKey, key)
+	for i := range q.keys {
+		if q.keys[i] == key {
+			q.keys = append(q.keys[:i], q.keys[i+1:]...)
+			if i < q.next {
+				q.next--
+			}
+			break
+		}
+	}
+}
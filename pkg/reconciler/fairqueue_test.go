@@ -0,0 +1,227 @@
+// Copyright 2022 Matrix Origin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reconciler
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr/funcr"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/workqueue"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	recon "sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+func fairReq(ns, name string) recon.Request {
+	return recon.Request{NamespacedName: types.NamespacedName{Namespace: ns, Name: name}}
+}
+
+func newTestFairQueue(maxPerKey int, deleting map[recon.Request]bool) *fairQueue {
+	return newFairQueue(workqueue.DefaultControllerRateLimiter(), maxPerKey, func(req recon.Request) (string, bool) {
+		return req.Namespace, deleting[req]
+	})
+}
+
+// getter gets requests from q through the blocking Get in the background until the test ends
+func getter(t *testing.T, q *fairQueue) <-chan recon.Request {
+	ch := make(chan recon.Request)
+	stop := make(chan struct{})
+	go func() {
+		for {
+			req, shutdown := q.Get()
+			if shutdown {
+				return
+			}
+			select {
+			case ch <- req:
+			case <-stop:
+				return
+			}
+		}
+	}()
+	t.Cleanup(func() {
+		close(stop)
+		q.ShutDown()
+	})
+	return ch
+}
+
+// get receives the next request of the getter, false is returned if none is available within a short timeout
+func get(ch <-chan recon.Request) (recon.Request, bool) {
+	select {
+	case req := <-ch:
+		return req, true
+	case <-time.After(100 * time.Millisecond):
+		return recon.Request{}, false
+	}
+}
+
+func TestFairQueueRoundRobin(t *testing.T) {
+	g := NewGomegaWithT(t)
+	q := newTestFairQueue(10, nil)
+	for _, name := range []string{"a1", "a2", "a3"} {
+		q.Add(fairReq("a", name))
+	}
+	q.Add(fairReq("b", "b1"))
+	q.Add(fairReq("c", "c1"))
+
+	ch := getter(t, q)
+	var got []string
+	for {
+		req, ok := get(ch)
+		if !ok {
+			break
+		}
+		got = append(got, req.Name)
+		q.Done(req)
+	}
+	g.Expect(got).To(Equal([]string{"a1", "b1", "c1", "a2", "a3"}))
+}
+
+func TestFairQueuePerKeyLimit(t *testing.T) {
+	g := NewGomegaWithT(t)
+	q := newTestFairQueue(1, nil)
+	q.Add(fairReq("a", "a1"))
+	q.Add(fairReq("a", "a2"))
+	q.Add(fairReq("b", "b1"))
+
+	ch := getter(t, q)
+	a1, ok := get(ch)
+	g.Expect(ok).To(BeTrue())
+	g.Expect(a1.Name).To(Equal("a1"))
+	b1, ok := get(ch)
+	g.Expect(ok).To(BeTrue())
+	g.Expect(b1.Name).To(Equal("b1"))
+	// namespace a is at its limit
+	_, ok = get(ch)
+	g.Expect(ok).To(BeFalse())
+
+	q.Done(a1)
+	a2, ok := get(ch)
+	g.Expect(ok).To(BeTrue())
+	g.Expect(a2.Name).To(Equal("a2"))
+}
+
+func TestFairQueueDeletionFirst(t *testing.T) {
+	g := NewGomegaWithT(t)
+	deleting := map[recon.Request]bool{}
+	q := newTestFairQueue(10, deleting)
+	q.Add(fairReq("a", "a1"))
+	q.Add(fairReq("b", "b1"))
+	q.Add(fairReq("c", "c1"))
+
+	// a queued update is promoted once the object is being deleted
+	deleting[fairReq("b", "b1")] = true
+	q.Add(fairReq("b", "b1"))
+	ch := getter(t, q)
+	req, _ := get(ch)
+	g.Expect(req.Name).To(Equal("b1"))
+	req, _ = get(ch)
+	g.Expect(req.Name).To(Equal("a1"))
+	req, _ = get(ch)
+	g.Expect(req.Name).To(Equal("c1"))
+	g.Expect(q.Len()).To(Equal(0))
+}
+
+func TestFairQueueDedup(t *testing.T) {
+	g := NewGomegaWithT(t)
+	q := newTestFairQueue(10, nil)
+	q.Add(fairReq("a", "a1"))
+	q.Add(fairReq("a", "a1"))
+	g.Expect(q.Len()).To(Equal(1))
+
+	req, shutdown := q.Get()
+	g.Expect(shutdown).To(BeFalse())
+	// added while processing, requeued when done
+	q.Add(req)
+	g.Expect(q.Len()).To(Equal(0))
+	q.Done(req)
+	g.Expect(q.Len()).To(Equal(1))
+
+	q.ShutDown()
+	_, shutdown = q.Get()
+	g.Expect(shutdown).To(BeTrue())
+}
+
+func TestFairQueueShutDownStopsTimers(t *testing.T) {
+	g := NewGomegaWithT(t)
+	q := newTestFairQueue(10, nil)
+	q.AddAfter(fairReq("a", "a1"), time.Hour)
+	g.Expect(q.timers).To(HaveLen(1))
+	q.ShutDown()
+	g.Expect(q.timers).To(BeEmpty())
+	q.AddAfter(fairReq("a", "a1"), time.Hour)
+	g.Expect(q.timers).To(BeEmpty())
+}
+
+func TestProcessNextFairRecoverPanic(t *testing.T) {
+	g := NewGomegaWithT(t)
+	obj := &testObject{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "test", Generation: 1}}
+	actor := &testActor{ObserveFn: func(ctx *Context[*testObject]) (Action[*testObject], error) {
+		logf.FromContext(ctx).Info("observe")
+		panic("boom")
+	}}
+	r, _ := newTestReconciler(t, actor, &options{skipFinalizer: true}, obj)
+	r.name = "fair-panic"
+	var logs []string
+	r.logger = funcr.New(func(prefix, args string) { logs = append(logs, args) }, funcr.Options{})
+	r.fairQueue = &fairQueueOptions{}
+	r.queue = newFairQueue(workqueue.DefaultControllerRateLimiter(), 1, r.classify)
+	r.queue.Add(fairReq("default", "test"))
+
+	r.recoverPanic = true
+	g.Expect(r.processNextFair(context.Background())).To(BeTrue())
+	// the context carries the logger of the reconcile like the controller does
+	g.Expect(logs).To(ContainElement(ContainSubstring(`"reconcileID"`)))
+	g.Expect(testutil.ToFloat64(fairReconcileErrors.WithLabelValues(r.name))).To(Equal(float64(1)))
+	g.Expect(testutil.ToFloat64(fairReconcileTotal.WithLabelValues(r.name, "error"))).To(Equal(float64(1)))
+	// the request is retried after the backoff
+	g.Eventually(r.queue.Len, time.Second).Should(Equal(1))
+
+	r.recoverPanic = false
+	g.Expect(func() { r.processNextFair(context.Background()) }).To(PanicWith("boom"))
+}
+
+func TestReconcileFairQueue(t *testing.T) {
+	g := NewGomegaWithT(t)
+	obj := &testObject{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "test", Generation: 1}}
+	observed := make(chan struct{}, 10)
+	actor := &testActor{ObserveFn: func(*Context[*testObject]) (Action[*testObject], error) {
+		observed <- struct{}{}
+		return nil, nil
+	}}
+	r, _ := newTestReconciler(t, actor, &options{skipFinalizer: true}, obj)
+	r.fairQueue = &fairQueueOptions{}
+	r.queue = newFairQueue(workqueue.DefaultControllerRateLimiter(), 1, r.classify)
+
+	req := recon.Request{NamespacedName: types.NamespacedName{Namespace: "default", Name: "test"}}
+	res, err := r.Reconcile(context.Background(), req)
+	g.Expect(err).To(Succeed())
+	g.Expect(res).To(Equal(forget))
+	g.Expect(r.queue.Len()).To(Equal(1))
+	g.Expect(observed).To(BeEmpty())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- r.runFairQueue(ctx) }()
+	g.Eventually(observed, time.Second).Should(Receive())
+	cancel()
+	g.Eventually(done, time.Second).Should(Receive(BeNil()))
+}
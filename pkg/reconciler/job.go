@@ -0,0 +1,233 @@
+// Copyright 2022 Matrix Origin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reconciler
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"time"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// JobStepLabel is the name of the step of a Job created by RunJobStep
+	JobStepLabel = "reconcile.matrixorigin.io/jobStep"
+	// JobGenerationLabel is the generation of the owner when a Job is created by RunJobStep
+	JobGenerationLabel = "reconcile.matrixorigin.io/jobGeneration"
+
+	defaultJobPollInterval = 10 * time.Second
+	defaultJobTTL          = time.Hour
+
+	maxJobNameLength = 63
+)
+
+// JobStepOptions tunes RunJobStep
+type JobStepOptions struct {
+	// PollInterval is the interval to check the progress of the Job, defaults to 10s
+	PollInterval time.Duration
+	// TTL is how long the finished Jobs of previous generations are kept before being deleted, defaults to 1h.
+	// The Job of the current generation is always kept as the record of the step.
+	TTL time.Duration
+}
+
+// RunJobStep runs the step as a Job owned by ctx.Obj, the Job is created from tpl once per generation of the
+// object. ReSync is returned until the Job completes and nil is returned afterwards, a failed Job is
+// reported as an error with the reason of the failure. The running Job of a previous generation is deleted
+// and waited to be gone before the Job of the current generation is created.
+// A Terminal error is returned if T does not have a status subresource, see AllowUpdateWithoutStatusSubresource.
+func RunJobStep[T client.Object](ctx *Context[T], step string, tpl *batchv1.Job, opts JobStepOptions) error {
+	if ctx.noStatusSubresource {
+		return ErrTerminal(fmt.Errorf("job step %s requires the status subresource, the Job is keyed on the generation bumped by each status update", step))
+	}
+	if opts.PollInterval == 0 {
+		opts.PollInterval = defaultJobPollInterval
+	}
+	if opts.TTL == 0 {
+		opts.TTL = defaultJobTTL
+	}
+	if err := cleanupJobs(ctx, step, opts.TTL); err != nil {
+		return err
+	}
+	generation := ctx.Obj.GetGeneration()
+	job := &batchv1.Job{}
+	err := ctx.Get(client.ObjectKey{Namespace: ctx.Obj.GetNamespace(), Name: jobStepName(ctx.Obj, step)}, job)
+	if apierrors.IsNotFound(err) {
+		stopping, err := stopPreviousJobs(ctx, step)
+		if err != nil {
+			return err
+		}
+		if stopping {
+			return ErrReSync(fmt.Sprintf("waiting for the running jobs of step %s of previous generations to stop", step), opts.PollInterval)
+		}
+		job = tpl.DeepCopy()
+		job.Namespace = ctx.Obj.GetNamespace()
+		job.Name = jobStepName(ctx.Obj, step)
+		if job.Labels == nil {
+			job.Labels = map[string]string{}
+		}
+		job.Labels[JobStepLabel] = step
+		job.Labels[JobGenerationLabel] = strconv.FormatInt(generation, 10)
+		if err := ctx.CreateOwned(job); err != nil {
+			return err
+		}
+		return ErrReSync(fmt.Sprintf("job %s of step %s created", job.Name, step), opts.PollInterval)
+	}
+	if err != nil {
+		return err
+	}
+	if c := jobCondition(job, batchv1.JobComplete); c != nil {
+		return nil
+	}
+	if c := jobCondition(job, batchv1.JobFailed); c != nil {
+		reason := fmt.Sprintf("%s: %s", c.Reason, c.Message)
+		if podReason, err := jobFailureReason(ctx, job); err != nil {
+			ctx.Log.Error(err, "failed to get the failure reason from pods of job", "job", job.Name)
+		} else if podReason != "" {
+			reason = fmt.Sprintf("%s, %s", reason, podReason)
+		}
+		return fmt.Errorf("job %s of step %s failed, %s", job.Name, step, reason)
+	}
+	return ErrReSync(fmt.Sprintf("job %s of step %s is running", job.Name, step), opts.PollInterval)
+}
+
+// jobStepName returns a stable name of the Job of the step at the current generation of obj
+func jobStepName(obj client.Object, step string) string {
+	name := fmt.Sprintf("%s-%s-%d", obj.GetName(), step, obj.GetGeneration())
+	if len(name) <= maxJobNameLength {
+		return name
+	}
+	h := sha256.Sum256([]byte(name))
+	suffix := hex.EncodeToString(h[:])[:10]
+	return fmt.Sprintf("%s-%s", name[:maxJobNameLength-len(suffix)-1], suffix)
+}
+
+func jobCondition(job *batchv1.Job, condType batchv1.JobConditionType) *batchv1.JobCondition {
+	for i := range job.Status.Conditions {
+		c := &job.Status.Conditions[i]
+		if c.Type == condType && c.Status == corev1.ConditionTrue {
+			return c
+		}
+	}
+	return nil
+}
+
+// jobFailureReason finds the reason of the last terminated container of the failed pods of the job,
+// the message is the tail of the logs if the container uses FallbackToLogsOnError termination message policy
+func jobFailureReason[T client.Object](ctx *Context[T], job *batchv1.Job) (string, error) {
+	if job.Spec.Selector == nil {
+		return "", nil
+	}
+	selector, err := metav1.LabelSelectorAsSelector(job.Spec.Selector)
+	if err != nil {
+		return "", err
+	}
+	pods := &corev1.PodList{}
+	if err := ctx.List(pods, client.InNamespace(job.Namespace), client.MatchingLabelsSelector{Selector: selector}); err != nil {
+		return "", err
+	}
+	var reason string
+	var last time.Time
+	for _, pod := range pods.Items {
+		for _, cs := range pod.Status.ContainerStatuses {
+			t := cs.State.Terminated
+			if t == nil || t.ExitCode == 0 || t.FinishedAt.Time.Before(last) {
+				continue
+			}
+			last = t.FinishedAt.Time
+			reason = fmt.Sprintf("container %s of pod %s terminated with exit code %d: %s %s", cs.Name, pod.Name, t.ExitCode, t.Reason, t.Message)
+		}
+	}
+	return reason, nil
+}
+
+// cleanupJobs deletes the finished Jobs of the step of previous generations after ttl
+func cleanupJobs[T client.Object](ctx *Context[T], step string, ttl time.Duration) error {
+	jobs, err := previousJobs(ctx, step)
+	if err != nil {
+		return err
+	}
+	for i := range jobs {
+		job := &jobs[i]
+		finished := jobFinished(job)
+		if finished == nil || time.Since(finished.LastTransitionTime.Time) < ttl {
+			continue
+		}
+		if err := ctx.Delete(job, client.PropagationPolicy(metav1.DeletePropagationBackground)); client.IgnoreNotFound(err) != nil {
+			return err
+		}
+		ctx.Log.Info("delete finished job", "job", job.Name, "step", step)
+	}
+	return nil
+}
+
+// stopPreviousJobs deletes the running Jobs of the step of previous generations and returns whether any of
+// them is still stopping. The foreground propagation keeps the Job until its pods are gone, so that Jobs of
+// different generations never run at the same time.
+func stopPreviousJobs[T client.Object](ctx *Context[T], step string) (bool, error) {
+	jobs, err := previousJobs(ctx, step)
+	if err != nil {
+		return false, err
+	}
+	stopping := false
+	for i := range jobs {
+		job := &jobs[i]
+		if jobFinished(job) != nil {
+			continue
+		}
+		stopping = true
+		if job.DeletionTimestamp != nil {
+			continue
+		}
+		if err := ctx.Delete(job, client.PropagationPolicy(metav1.DeletePropagationForeground)); client.IgnoreNotFound(err) != nil {
+			return false, err
+		}
+		ctx.Log.Info("stop running job of previous generation", "job", job.Name, "step", step)
+	}
+	return stopping, nil
+}
+
+// previousJobs lists the Jobs of the step of previous generations owned by ctx.Obj
+func previousJobs[T client.Object](ctx *Context[T], step string) ([]batchv1.Job, error) {
+	jobs := &batchv1.JobList{}
+	if err := ctx.List(jobs, client.InNamespace(ctx.Obj.GetNamespace()), client.MatchingLabelsSelector{
+		Selector: labels.SelectorFromSet(labels.Set{JobStepLabel: step}),
+	}); err != nil {
+		return nil, err
+	}
+	current := strconv.FormatInt(ctx.Obj.GetGeneration(), 10)
+	var previous []batchv1.Job
+	for _, job := range jobs.Items {
+		if isOwnedBy(&job, ctx.Obj.GetUID()) && job.Labels[JobGenerationLabel] != current {
+			previous = append(previous, job)
+		}
+	}
+	return previous, nil
+}
+
+// jobFinished returns the condition that finishes the job, or nil if the job is running
+func jobFinished(job *batchv1.Job) *batchv1.JobCondition {
+	if c := jobCondition(job, batchv1.JobComplete); c != nil {
+		return c
+	}
+	return jobCondition(job, batchv1.JobFailed)
+}
@@ -0,0 +1,148 @@
+// Copyright 2022 Matrix Origin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reconciler
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	kubefake "sigs.k8s.io/controller-runtime/pkg/client/fake"
+	recon "sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+func TestRunJobStep(t *testing.T) {
+	g := NewGomegaWithT(t)
+	obj := &testObject{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "test", UID: "uid", Generation: 2}}
+	cli := kubefake.NewClientBuilder().WithScheme(newTestScheme()).WithObjects(obj).Build()
+	ctx := newTestContext(obj, cli)
+	tpl := &batchv1.Job{Spec: batchv1.JobSpec{
+		Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"job": "bootstrap"}},
+		Template: corev1.PodTemplateSpec{Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "main", Image: "bootstrap"}}}},
+	}}
+
+	var resync *ReSync
+	g.Expect(RunJobStep(ctx, "bootstrap", tpl, JobStepOptions{})).To(BeAssignableToTypeOf(resync))
+	job := &batchv1.Job{}
+	key := client.ObjectKey{Namespace: "default", Name: "test-bootstrap-2"}
+	g.Expect(cli.Get(context.Background(), key, job)).To(Succeed())
+	g.Expect(job.Labels).To(HaveKeyWithValue(JobStepLabel, "bootstrap"))
+	g.Expect(isOwnedBy(job, obj.UID)).To(BeTrue())
+
+	// still running
+	g.Expect(RunJobStep(ctx, "bootstrap", tpl, JobStepOptions{})).To(BeAssignableToTypeOf(resync))
+
+	// the failure reason of the pod is surfaced
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "test-bootstrap-2-abcde", Labels: map[string]string{"job": "bootstrap"}},
+		Status: corev1.PodStatus{ContainerStatuses: []corev1.ContainerStatus{{
+			Name: "main",
+			State: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{
+				ExitCode: 1,
+				Reason:   "Error",
+				Message:  "schema already exists",
+			}},
+		}}},
+	}
+	g.Expect(cli.Create(context.Background(), pod)).To(Succeed())
+	job.Status.Conditions = []batchv1.JobCondition{{Type: batchv1.JobFailed, Status: corev1.ConditionTrue, Reason: "BackoffLimitExceeded"}}
+	g.Expect(cli.Status().Update(context.Background(), job)).To(Succeed())
+	err := RunJobStep(ctx, "bootstrap", tpl, JobStepOptions{})
+	g.Expect(err).To(HaveOccurred())
+	g.Expect(err.Error()).To(ContainSubstring("BackoffLimitExceeded"))
+	g.Expect(err.Error()).To(ContainSubstring("schema already exists"))
+
+	job.Status.Conditions = []batchv1.JobCondition{{
+		Type:               batchv1.JobComplete,
+		Status:             corev1.ConditionTrue,
+		LastTransitionTime: metav1.NewTime(time.Now().Add(-2 * time.Hour)),
+	}}
+	g.Expect(cli.Status().Update(context.Background(), job)).To(Succeed())
+	g.Expect(RunJobStep(ctx, "bootstrap", tpl, JobStepOptions{})).To(Succeed())
+
+	// the finished job of the previous generation is cleaned up after the TTL
+	obj.Generation = 3
+	g.Expect(RunJobStep(ctx, "bootstrap", tpl, JobStepOptions{})).To(BeAssignableToTypeOf(resync))
+	g.Expect(apierrors.IsNotFound(cli.Get(context.Background(), key, &batchv1.Job{}))).To(BeTrue())
+	job3 := &batchv1.Job{}
+	key3 := client.ObjectKey{Namespace: "default", Name: "test-bootstrap-3"}
+	g.Expect(cli.Get(context.Background(), key3, job3)).To(Succeed())
+
+	// the running job of the previous generation is stopped before the job of the new generation is created
+	job3.Finalizers = []string{"test.matrixorigin.io/pods"}
+	g.Expect(cli.Update(context.Background(), job3)).To(Succeed())
+	obj.Generation = 4
+	key4 := client.ObjectKey{Namespace: "default", Name: "test-bootstrap-4"}
+	for i := 0; i < 2; i++ {
+		g.Expect(RunJobStep(ctx, "bootstrap", tpl, JobStepOptions{})).To(BeAssignableToTypeOf(resync))
+		g.Expect(apierrors.IsNotFound(cli.Get(context.Background(), key4, &batchv1.Job{}))).To(BeTrue())
+	}
+	g.Expect(cli.Get(context.Background(), key3, job3)).To(Succeed())
+	g.Expect(job3.DeletionTimestamp).ToNot(BeNil())
+	// the pods are gone
+	job3.Finalizers = nil
+	g.Expect(cli.Update(context.Background(), job3)).To(Succeed())
+	g.Expect(RunJobStep(ctx, "bootstrap", tpl, JobStepOptions{})).To(BeAssignableToTypeOf(resync))
+	g.Expect(cli.Get(context.Background(), key4, &batchv1.Job{})).To(Succeed())
+}
+
+func TestReconcileJobStepStalls(t *testing.T) {
+	g := NewGomegaWithT(t)
+	obj := &testObject{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "hung", UID: "uid", Generation: 1}}
+	tpl := &batchv1.Job{Spec: batchv1.JobSpec{
+		Template: corev1.PodTemplateSpec{Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "main", Image: "bootstrap"}}}},
+	}}
+	actor := &testActor{ObserveFn: func(*Context[*testObject]) (Action[*testObject], error) {
+		return NamedAction(ActionInfo{Name: "bootstrap"}, func(ctx *Context[*testObject]) error {
+			return RunJobStep(ctx, "bootstrap", tpl, JobStepOptions{})
+		}), nil
+	}}
+	r, cli := newTestReconciler(t, actor, &options{skipFinalizer: true, progressDeadline: time.Minute}, obj)
+	req := recon.Request{NamespacedName: client.ObjectKeyFromObject(obj)}
+	got := &testObject{}
+
+	// the job is created and never finishes
+	for i := 0; i < 2; i++ {
+		_, err := r.Reconcile(context.Background(), req)
+		g.Expect(err).To(Succeed())
+	}
+	g.Expect(cli.Get(context.Background(), client.ObjectKey{Namespace: "default", Name: "hung-bootstrap-1"}, &batchv1.Job{})).To(Succeed())
+	r.progress.objects[req.NamespacedName].since = time.Now().Add(-time.Hour)
+	_, err := r.Reconcile(context.Background(), req)
+	g.Expect(err).To(Succeed())
+	g.Expect(cli.Get(context.Background(), req.NamespacedName, got)).To(Succeed())
+	c := meta.FindStatusCondition(got.GetConditions(), ConditionTypeStalled)
+	g.Expect(c).ToNot(BeNil())
+	g.Expect(c.Status).To(Equal(metav1.ConditionTrue))
+	g.Expect(c.Reason).To(Equal(reasonProgressDeadlineExceeded))
+	r.resetProgress(req.NamespacedName, nil)
+}
+
+func TestJobStepName(t *testing.T) {
+	g := NewGomegaWithT(t)
+	obj := &testObject{ObjectMeta: metav1.ObjectMeta{Name: strings.Repeat("a", 60), Generation: 1}}
+	name := jobStepName(obj, "bootstrap")
+	g.Expect(len(name)).To(Equal(maxJobNameLength))
+	g.Expect(jobStepName(obj, "bootstrap")).To(Equal(name))
+	g.Expect(jobStepName(obj, "import")).ToNot(Equal(name))
+}
@@ -0,0 +1,90 @@
+// Copyright 2022 Matrix Origin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reconciler
+
+import (
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// kstatus conditions, see https://github.com/kubernetes-sigs/cli-utils/blob/master/pkg/kstatus/README.md
+const (
+	// ConditionTypeReconciling Whether the controller is working on reaching the desired state of the object
+	ConditionTypeReconciling = "Reconciling"
+	// ConditionTypeStalled Whether the controller encountered an error that will not recover without intervention
+	ConditionTypeStalled = "Stalled"
+)
+
+const (
+	reasonProgressing = "Progressing"
+	reasonSynced      = "Synced"
+)
+
+// GenerationObserver is implemented by objects that record the latest generation handled by the
+// controller in the top-level status.observedGeneration field
+type GenerationObserver interface {
+	SetObservedGeneration(generation int64)
+}
+
+// WithKStatusConditions makes the reconciler maintain kstatus-compliant Reconciling and Stalled conditions
+// alongside Synced, and the top-level status.observedGeneration if T implements GenerationObserver.
+// The Ready condition is left to the actor.
+func WithKStatusConditions() ApplyOption {
+	return func(o *options) { o.kstatus = true }
+}
+
+// setKStatus records the reconciling progress of obj in kstatus conditions, a non-nil stalledErr
+// implies the reconciliation is blocked by a Terminal error.
+func (r *Reconciler[T]) setKStatus(obj T, reconciling bool, stalledErr error) {
+	if !r.kstatus {
+		return
+	}
+	generation := obj.GetGeneration()
+	if o, ok := any(obj).(GenerationObserver); ok {
+		o.SetObservedGeneration(generation)
+	}
+	cond, ok := any(obj).(Conditional)
+	if !ok {
+		return
+	}
+	switch {
+	case stalledErr != nil:
+		cond.SetCondition(kstatusCondition(ConditionTypeReconciling, false, reconcileFail, "the object is stalled", generation))
+		cond.SetCondition(kstatusCondition(ConditionTypeStalled, true, reconcileFail, stalledErr.Error(), generation))
+	case reconciling:
+		cond.SetCondition(kstatusCondition(ConditionTypeReconciling, true, reasonProgressing, "the object is reconciling", generation))
+		// the Stalled condition set by the progress deadline is kept until the deadline restarts or the object is synced
+		if c := meta.FindStatusCondition(cond.GetConditions(), ConditionTypeStalled); c == nil || c.Reason != reasonProgressDeadlineExceeded {
+			cond.SetCondition(kstatusCondition(ConditionTypeStalled, false, reasonProgressing, "the object is reconciling", generation))
+		}
+	default:
+		cond.SetCondition(kstatusCondition(ConditionTypeReconciling, false, reasonSynced, "the object is synced", generation))
+		cond.SetCondition(kstatusCondition(ConditionTypeStalled, false, reasonSynced, "the object is synced", generation))
+	}
+}
+
+func kstatusCondition(condType string, b bool, reason string, msg string, generation int64) metav1.Condition {
+	status := metav1.ConditionFalse
+	if b {
+		status = metav1.ConditionTrue
+	}
+	return metav1.Condition{
+		Type:               condType,
+		Status:             status,
+		ObservedGeneration: generation,
+		Reason:             reason,
+		Message:            msg,
+	}
+}
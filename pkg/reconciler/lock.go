@@ -0,0 +1,147 @@
+// Copyright 2022 Matrix Origin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reconciler
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// managerLocks holds the lock table of each running manager, so that reconciles sharing the same key are serialized
+// across all the reconcilers of a manager. The table is dropped when the manager stops.
+var managerLocks sync.Map
+
+// locksOf returns the lock table shared by the reconcilers of mgr
+func locksOf(mgr ctrl.Manager) (*keyedLocker, error) {
+	l, loaded := managerLocks.LoadOrStore(mgr, newKeyedLocker())
+	if !loaded {
+		if err := mgr.Add(&lockTableCleanup{mgr: mgr}); err != nil {
+			managerLocks.Delete(mgr)
+			return nil, err
+		}
+	}
+	return l.(*keyedLocker), nil
+}
+
+// lockTableCleanup drops the lock table of the manager when the manager stops
+type lockTableCleanup struct {
+	mgr ctrl.Manager
+}
+
+func (c *lockTableCleanup) Start(ctx context.Context) error {
+	<-ctx.Done()
+	managerLocks.Delete(c.mgr)
+	return nil
+}
+
+// NeedLeaderElection returns false to drop the table of managers that never become the leader as well
+func (c *lockTableCleanup) NeedLeaderElection() bool {
+	return false
+}
+
+// WithConcurrencyKey derives a concurrency key from T, reconciles of objects sharing the same key are serialized
+// across all the reconcilers of the manager, e.g. a key of the underlying StatefulSet serializes the reconcilers mutating it
+func WithConcurrencyKey[T client.Object](keyFn func(T) string) ApplyOption {
+	return func(o *options) {
+		o.concurrencyKey = func(obj client.Object) string {
+			return keyFn(obj.(T))
+		}
+	}
+}
+
+// Lock acquires the lock of the given key, which will be held until the current reconcile ends.
+// Reconciles locking the same key are serialized across all the reconcilers of the manager. Locking a key that is
+// already held by the current reconcile is a no-op. To avoid deadlock, multiple keys should be locked in a consistent
+// order.
+func (c *Context[T]) Lock(key string) error {
+	if c.locker == nil {
+		return fmt.Errorf("lock %s is not acquired by a reconciler", key)
+	}
+	if c.locks == nil {
+		c.locks = map[string]bool{}
+	}
+	if c.locks[key] {
+		return nil
+	}
+	if err := c.locker.lock(c, key); err != nil {
+		return err
+	}
+	c.locks[key] = true
+	return nil
+}
+
+// unlockAll releases all the locks held by the current reconcile
+func (c *Context[T]) unlockAll() {
+	for key := range c.locks {
+		c.locker.unlock(key)
+	}
+	c.locks = nil
+}
+
+type keyedLocker struct {
+	sync.Mutex
+	locks map[string]*keyedLock
+}
+
+type keyedLock struct {
+	// sem is a semaphore of size 1, so that waiting for the lock can be canceled
+	sem chan struct{}
+	// refs counts the holder and waiters of the lock
+	refs int
+}
+
+func newKeyedLocker() *keyedLocker {
+	return &keyedLocker{locks: map[string]*keyedLock{}}
+}
+
+func (l *keyedLocker) lock(ctx context.Context, key string) error {
+	l.Lock()
+	kl, ok := l.locks[key]
+	if !ok {
+		kl = &keyedLock{sem: make(chan struct{}, 1)}
+		l.locks[key] = kl
+	}
+	kl.refs++
+	l.Unlock()
+
+	select {
+	case kl.sem <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		l.release(key, kl)
+		return ctx.Err()
+	}
+}
+
+func (l *keyedLocker) unlock(key string) {
+	l.Lock()
+	kl := l.locks[key]
+	l.Unlock()
+	<-kl.sem
+	l.release(key, kl)
+}
+
+func (l *keyedLocker) release(key string, kl *keyedLock) {
+	l.Lock()
+	defer l.Unlock()
+	kl.refs--
+	if kl.refs == 0 {
+		delete(l.locks, key)
+	}
+}
@@ -0,0 +1,137 @@
+// Copyright 2022 Matrix Origin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reconciler
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+)
+
+func TestKeyedLocker(t *testing.T) {
+	g := NewGomegaWithT(t)
+	l := newKeyedLocker()
+
+	var mu sync.Mutex
+	running, maxRunning := 0, 0
+	errs := make(chan error, 10)
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := l.lock(context.Background(), "a"); err != nil {
+				errs <- err
+				return
+			}
+			mu.Lock()
+			running++
+			if running > maxRunning {
+				maxRunning = running
+			}
+			mu.Unlock()
+			time.Sleep(time.Millisecond)
+			mu.Lock()
+			running--
+			mu.Unlock()
+			l.unlock("a")
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		g.Expect(err).To(Succeed())
+	}
+	g.Expect(maxRunning).To(Equal(1))
+	g.Expect(l.locks).To(BeEmpty())
+
+	// unrelated keys proceed in parallel
+	g.Expect(l.lock(context.Background(), "a")).To(Succeed())
+	g.Expect(l.lock(context.Background(), "b")).To(Succeed())
+
+	// waiting can be canceled
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	g.Expect(l.lock(ctx, "a")).ToNot(Succeed())
+	l.unlock("a")
+	l.unlock("b")
+	g.Expect(l.locks).To(BeEmpty())
+}
+
+func TestContextLock(t *testing.T) {
+	g := NewGomegaWithT(t)
+	locker := newKeyedLocker()
+	ctx := newTestContext(&corev1.Pod{}, nil)
+	ctx.locker = locker
+	g.Expect(ctx.Lock("test")).To(Succeed())
+	// reentrant
+	g.Expect(ctx.Lock("test")).To(Succeed())
+
+	other := newTestContext(&corev1.Pod{}, nil)
+	other.locker = locker
+	timeout, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	other.Context = timeout
+	g.Expect(other.Lock("test")).ToNot(Succeed())
+
+	// contexts of another lock table proceed
+	isolated := newTestContext(&corev1.Pod{}, nil)
+	isolated.locker = newKeyedLocker()
+	g.Expect(isolated.Lock("test")).To(Succeed())
+	isolated.unlockAll()
+
+	ctx.unlockAll()
+	other.Context = context.Background()
+	g.Expect(other.Lock("test")).To(Succeed())
+	other.unlockAll()
+
+	// locks are only available to contexts built by a reconciler
+	g.Expect(newTestContext(&corev1.Pod{}, nil).Lock("test")).ToNot(Succeed())
+}
+
+// runnableManager records the runnables added to the manager
+type runnableManager struct {
+	ctrl.Manager
+	runnables []manager.Runnable
+}
+
+func (m *runnableManager) Add(r manager.Runnable) error {
+	m.runnables = append(m.runnables, r)
+	return nil
+}
+
+func TestLocksOf(t *testing.T) {
+	g := NewGomegaWithT(t)
+	mgr := &runnableManager{}
+	l, err := locksOf(mgr)
+	g.Expect(err).To(Succeed())
+	shared, err := locksOf(mgr)
+	g.Expect(err).To(Succeed())
+	g.Expect(shared).To(BeIdenticalTo(l))
+	g.Expect(mgr.runnables).To(HaveLen(1))
+
+	// the table is dropped when the manager stops
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	g.Expect(mgr.runnables[0].Start(ctx)).To(Succeed())
+	_, ok := managerLocks.Load(mgr)
+	g.Expect(ok).To(BeFalse())
+}
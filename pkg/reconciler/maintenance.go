@@ -0,0 +1,118 @@
+// Copyright 2022 Matrix Origin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reconciler
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/matrixorigin/controller-runtime/pkg/util"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const reasonMaintenanceWindow = "OutsideMaintenanceWindow"
+
+// MaintenanceWindow is a recurring period in which maintenance-only actions are allowed to run
+// +kubebuilder:object:generate=true
+type MaintenanceWindow struct {
+	// Schedule is a 5-field cron expression (minute hour day-of-month month day-of-week) or a
+	// descriptor like @daily, which defines the start of each window
+	Schedule string `json:"schedule"`
+	// Duration is the length of each window
+	Duration metav1.Duration `json:"duration"`
+	// TimeZone is the IANA time zone of the schedule, defaults to UTC
+	// +optional
+	TimeZone string `json:"timeZone,omitempty"`
+}
+
+// MaintenanceWindowed is implemented by objects that declare their maintenance windows,
+// an object that declares no window can be maintained at any time
+type MaintenanceWindowed interface {
+	GetMaintenanceWindows() []MaintenanceWindow
+}
+
+// MaintenanceOnly marks the action as maintenance-only, which will be deferred until the next maintenance
+// window of the object opens. The reconciler requeues the object at the start of the next window and reports
+// it in the Waiting condition.
+func MaintenanceOnly[T client.Object](action Action[T]) Action[T] {
+	return func(ctx *Context[T]) error {
+		ctx.markAction(action, func(info *ActionInfo) { info.MaintenanceOnly = true })
+		if err := ctx.waitMaintenanceWindow(time.Now()); err != nil {
+			return err
+		}
+		return action(ctx)
+	}
+}
+
+func (c *Context[T]) waitMaintenanceWindow(now time.Time) error {
+	w, ok := any(c.Obj).(MaintenanceWindowed)
+	if !ok {
+		return nil
+	}
+	open, next, err := nextMaintenanceWindow(w.GetMaintenanceWindows(), now)
+	if err != nil {
+		return err
+	}
+	cond, isConditional := any(c.Obj).(Conditional)
+	if !open {
+		if next.IsZero() {
+			return fmt.Errorf("maintenance windows of the object never open")
+		}
+		msg := fmt.Sprintf("waiting for the next maintenance window starting at %s", next.Format(time.RFC3339))
+		if isConditional {
+			cond.SetCondition(metav1.Condition{
+				Type:               ConditionTypeWaiting,
+				Status:             metav1.ConditionTrue,
+				ObservedGeneration: c.Obj.GetGeneration(),
+				Reason:             reasonMaintenanceWindow,
+				Message:            msg,
+			})
+		}
+		return ErrWait(msg, next.Sub(now))
+	}
+	clearWaiting(c.Obj, reasonMaintenanceWindow, "maintenance window is open")
+	return nil
+}
+
+// nextMaintenanceWindow returns whether any of the windows is open at now, otherwise the earliest start of
+// the following windows, which is zero if none of the windows will ever open. No window means always open.
+func nextMaintenanceWindow(windows []MaintenanceWindow, now time.Time) (bool, time.Time, error) {
+	if len(windows) == 0 {
+		return true, time.Time{}, nil
+	}
+	var next time.Time
+	for _, w := range windows {
+		sched, err := util.ParseCron(w.Schedule)
+		if err != nil {
+			return false, time.Time{}, err
+		}
+		loc := time.UTC
+		if w.TimeZone != "" {
+			if loc, err = time.LoadLocation(w.TimeZone); err != nil {
+				return false, time.Time{}, fmt.Errorf("invalid time zone of maintenance window: %v", err)
+			}
+		}
+		local := now.In(loc)
+		// the window is open if it started within the last duration
+		if start := sched.Next(local.Add(-w.Duration.Duration)); !start.IsZero() && !start.After(local) {
+			return true, start, nil
+		}
+		if start := sched.Next(local); !start.IsZero() && (next.IsZero() || start.Before(next)) {
+			next = start
+		}
+	}
+	return false, next, nil
+}
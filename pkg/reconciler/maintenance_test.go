@@ -0,0 +1,126 @@
+// Copyright 2022 Matrix Origin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reconciler
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	recon "sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+func TestNextMaintenanceWindow(t *testing.T) {
+	// 2023-06-07 is a Wednesday
+	now := time.Date(2023, 6, 7, 10, 30, 0, 0, time.UTC)
+	saturday := MaintenanceWindow{Schedule: "0 2 * * 6", Duration: metav1.Duration{Duration: 4 * time.Hour}}
+	tests := []struct {
+		name     string
+		windows  []MaintenanceWindow
+		wantOpen bool
+		wantNext time.Time
+	}{{
+		name:     "no window",
+		wantOpen: true,
+	}, {
+		name:     "outside window",
+		windows:  []MaintenanceWindow{saturday},
+		wantNext: time.Date(2023, 6, 10, 2, 0, 0, 0, time.UTC),
+	}, {
+		name: "inside window",
+		windows: []MaintenanceWindow{{
+			Schedule: "0 9 * * *",
+			Duration: metav1.Duration{Duration: 2 * time.Hour},
+		}},
+		wantOpen: true,
+		wantNext: time.Date(2023, 6, 7, 9, 0, 0, 0, time.UTC),
+	}, {
+		name: "earliest of windows in time zone",
+		windows: []MaintenanceWindow{saturday, {
+			Schedule: "0 22 * * *",
+			Duration: metav1.Duration{Duration: time.Hour},
+			TimeZone: "Asia/Shanghai",
+		}},
+		wantNext: time.Date(2023, 6, 7, 14, 0, 0, 0, time.UTC),
+	}, {
+		name:    "never open",
+		windows: []MaintenanceWindow{{Schedule: "0 0 30 2 *", Duration: metav1.Duration{Duration: time.Hour}}},
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewGomegaWithT(t)
+			open, next, err := nextMaintenanceWindow(tt.windows, now)
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(open).To(Equal(tt.wantOpen))
+			g.Expect(next.Equal(tt.wantNext)).To(BeTrue(), "got next window %v", next)
+		})
+	}
+}
+
+func TestMaintenanceOnlyDefersAction(t *testing.T) {
+	g := NewGomegaWithT(t)
+	now := time.Date(2023, 6, 7, 10, 30, 0, 0, time.UTC)
+	obj := &windowedTestObject{windows: []MaintenanceWindow{{
+		Schedule: "0 2 * * 6",
+		Duration: metav1.Duration{Duration: 4 * time.Hour},
+	}}}
+	ctx := newTestContext[*windowedTestObject](obj, nil)
+
+	err := ctx.waitMaintenanceWindow(now)
+	var resync *ReSync
+	g.Expect(err).To(BeAssignableToTypeOf(resync))
+	g.Expect(err.(*ReSync).RequeueAfter).To(Equal(2*24*time.Hour + 15*time.Hour + 30*time.Minute))
+	c := meta.FindStatusCondition(obj.GetConditions(), ConditionTypeWaiting)
+	g.Expect(c).ToNot(BeNil())
+	g.Expect(c.Status).To(Equal(metav1.ConditionTrue))
+	g.Expect(c.Message).To(ContainSubstring("2023-06-10T02:00:00Z"))
+
+	g.Expect(ctx.waitMaintenanceWindow(time.Date(2023, 6, 10, 3, 0, 0, 0, time.UTC))).To(Succeed())
+	g.Expect(meta.FindStatusCondition(obj.GetConditions(), ConditionTypeWaiting).Status).To(Equal(metav1.ConditionFalse))
+}
+
+func TestReconcileClearsMaintenanceWait(t *testing.T) {
+	g := NewGomegaWithT(t)
+	obj := &testObject{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "test", Generation: 1}}
+	obj.SetCondition(metav1.Condition{
+		Type:    ConditionTypeWaiting,
+		Status:  metav1.ConditionTrue,
+		Reason:  reasonMaintenanceWindow,
+		Message: "waiting for the next maintenance window starting at 2023-06-10T02:00:00Z",
+	})
+	r, cli := newTestReconciler(t, &testActor{}, &options{skipFinalizer: true}, obj)
+	req := recon.Request{NamespacedName: client.ObjectKeyFromObject(obj)}
+
+	_, err := r.Reconcile(context.Background(), req)
+	g.Expect(err).To(Succeed())
+	got := &testObject{}
+	g.Expect(cli.Get(context.Background(), req.NamespacedName, got)).To(Succeed())
+	c := meta.FindStatusCondition(got.GetConditions(), ConditionTypeWaiting)
+	g.Expect(c.Status).To(Equal(metav1.ConditionFalse))
+	g.Expect(c.Message).ToNot(ContainSubstring("waiting"))
+}
+
+type windowedTestObject struct {
+	testObject
+	windows []MaintenanceWindow
+}
+
+func (o *windowedTestObject) GetMaintenanceWindows() []MaintenanceWindow {
+	return o.windows
+}
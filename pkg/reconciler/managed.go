@@ -13,6 +13,8 @@
 // limitations under the License.
 package reconciler
 
+//go:generate controller-gen object:headerFile=../../hack/boilerplate.go.txt paths=.
+
 import (
 	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -98,6 +100,51 @@ func (c *ConditionalStatus) GetConditions() []metav1.Condition {
 	return c.Conditions
 }
 
+// Observed is implemented by objects that record the bookkeeping of reconciliation in status,
+// which will be populated by the reconciler on each pass
+type Observed interface {
+	GenerationObserver
+	SetLastReconcileTime(t metav1.Time)
+	SetLastSuccessfulReconcileTime(t metav1.Time)
+}
+
+// +kubebuilder:object:generate=true
+type ObservedStatus struct {
+	// ObservedGeneration is the latest generation handled by the reconciler
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+	// LastReconcileTime is the last time the object was reconciled
+	LastReconcileTime *metav1.Time `json:"lastReconcileTime,omitempty"`
+	// LastSuccessfulReconcileTime is the last time the object was observed synced
+	LastSuccessfulReconcileTime *metav1.Time `json:"lastSuccessfulReconcileTime,omitempty"`
+	// LastHandledReconcileAt is the last handled value of the ReconcileRequestAnnotation
+	LastHandledReconcileAt string `json:"lastHandledReconcileAt,omitempty"`
+	// NextRetryTime is the earliest time the reconciler will retry the object, if known
+	NextRetryTime *metav1.Time `json:"nextRetryTime,omitempty"`
+	// ConsecutiveFailures is the number of reconciliations of the object that have failed in a row
+	ConsecutiveFailures int32 `json:"consecutiveFailures,omitempty"`
+}
+
+func (o *ObservedStatus) SetObservedGeneration(generation int64) {
+	o.ObservedGeneration = generation
+}
+
+func (o *ObservedStatus) SetLastReconcileTime(t metav1.Time) {
+	o.LastReconcileTime = &t
+}
+
+func (o *ObservedStatus) SetLastSuccessfulReconcileTime(t metav1.Time) {
+	o.LastSuccessfulReconcileTime = &t
+}
+
+func (o *ObservedStatus) SetLastHandledReconcileAt(requestedAt string) {
+	o.LastHandledReconcileAt = requestedAt
+}
+
+func (o *ObservedStatus) SetNextRetry(nextRetryTime *metav1.Time, consecutiveFailures int32) {
+	o.NextRetryTime = nextRetryTime
+	o.ConsecutiveFailures = consecutiveFailures
+}
+
 func GetCondition(c Conditional, conditionType ConditionType) (*metav1.Condition, bool) {
 	cs := c.GetConditions()
 	for i := range cs {
@@ -0,0 +1,68 @@
+// Copyright 2022 Matrix Origin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reconciler
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	ctrlmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+const metricsNamespace = "matrixorigin_reconciler"
+
+var (
+	actionsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "actions_total",
+		Help:      "Total number of actions executed, partitioned by controller, sub-actor and action",
+	}, []string{"controller", "actor", "action"})
+	stalledObjects = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "stalled_objects",
+		Help:      "Number of objects that exceed the progress deadline without being synced, partitioned by controller",
+	}, []string{"controller"})
+	openCircuits = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "open_circuits",
+		Help:      "Number of open circuits of the circuit breaker, partitioned by controller",
+	}, []string{"controller"})
+	shortCircuitedTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "short_circuited_total",
+		Help:      "Total number of actions skipped by the open circuit breaker, partitioned by controller",
+	}, []string{"controller"})
+	// the reconciles done by the workers of the fair queue, the reconcile metrics of controller-runtime only
+	// count the passes of the requests through the controller into the fair queue
+	fairReconcileTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "fair_queue_reconcile_total",
+		Help:      "Total number of reconciliations done by the fair queue, partitioned by controller and result",
+	}, []string{"controller", "result"})
+	fairReconcileErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "fair_queue_reconcile_errors_total",
+		Help:      "Total number of reconciliation errors of the fair queue, partitioned by controller",
+	}, []string{"controller"})
+	fairReconcileTime = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "fair_queue_reconcile_time_seconds",
+		Help:      "Length of time per reconciliation of the fair queue, partitioned by controller",
+		Buckets:   prometheus.ExponentialBuckets(0.005, 2, 14),
+	}, []string{"controller"})
+)
+
+func init() {
+	ctrlmetrics.Registry.MustRegister(actionsTotal, stalledObjects, openCircuits, shortCircuitedTotal,
+		fairReconcileTotal, fairReconcileErrors, fairReconcileTime)
+}
@@ -0,0 +1,114 @@
+// Copyright 2022 Matrix Origin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reconciler
+
+import (
+	"fmt"
+
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// ActionInfo describes a NamedAction
+type ActionInfo struct {
+	// Name is the stable name of the action used in logs, events, conditions and metrics, e.g. scaleIn
+	Name string
+	// Description is a human-readable explanation of what the action does
+	Description string
+	// Disruptive actions consume the disruption budget of the reconciler, see Disruptive
+	Disruptive bool
+	// Idempotent actions are safe to be retried or executed repeatedly
+	Idempotent bool
+	// MaintenanceOnly actions are deferred until the maintenance window of the object, see MaintenanceOnly
+	MaintenanceOnly bool
+	// ApprovalPlan, if not empty, describes the plan of the action and makes the action require approval,
+	// see RequireApproval
+	ApprovalPlan string
+}
+
+// NamedAction builds an Action that carries a stable name and metadata. The reconciler uses them in logs,
+// events, conditions and metrics once the action executes, see DescribeAction. The flags in info are enforced
+// by gating the action with MaintenanceOnly, RequireApproval and Disruptive, in that order. The name and
+// flags are kept when the action is wrapped by the built-in gates.
+func NamedAction[T client.Object](info ActionInfo, action Action[T]) Action[T] {
+	run := action
+	if info.Disruptive {
+		run = Disruptive(run)
+	}
+	if info.ApprovalPlan != "" {
+		run = RequireApproval(info.Name, info.ApprovalPlan, run)
+	}
+	if info.MaintenanceOnly {
+		run = MaintenanceOnly(run)
+	}
+	return func(ctx *Context[T]) error {
+		ctx.beginAction(info)
+		return run(ctx)
+	}
+}
+
+// DescribeAction returns the ActionInfo of the NamedAction working in ctx, including the flags set by the
+// built-in gates wrapping it. The info is known once the NamedAction starts executing.
+func DescribeAction[T client.Object](ctx *Context[T]) (ActionInfo, bool) {
+	return ctx.actionInfo, ctx.named
+}
+
+// beginAction records the info of a starting NamedAction, the flags set by the enclosing gates are kept.
+// The outermost NamedAction names the action if NamedActions are nested.
+func (c *Context[T]) beginAction(info ActionInfo) {
+	gates := c.actionInfo
+	if !c.named {
+		c.actionInfo = info
+		c.named = true
+	}
+	c.actionInfo.Disruptive = c.actionInfo.Disruptive || info.Disruptive || gates.Disruptive
+	c.actionInfo.MaintenanceOnly = c.actionInfo.MaintenanceOnly || info.MaintenanceOnly || gates.MaintenanceOnly
+	if c.actionInfo.ApprovalPlan == "" {
+		c.actionInfo.ApprovalPlan = gates.ApprovalPlan
+	}
+}
+
+// markAction records the flag of a built-in gate and the function name of the action it wraps, so that a
+// wrapped NamedAction keeps the flag and a wrapped plain action is reported by its own name
+func (c *Context[T]) markAction(action Action[T], mark func(info *ActionInfo)) {
+	mark(&c.actionInfo)
+	if !c.named {
+		c.actionFunc = action.String()
+	}
+}
+
+// actionName returns the name of the NamedAction working in ctx, otherwise the function name of the action,
+// or of the action wrapped by the built-in gates
+func (c *Context[T]) actionName(action Action[T]) string {
+	switch {
+	case c.named:
+		return c.actionInfo.Name
+	case c.actionFunc != "":
+		return c.actionFunc
+	default:
+		return action.String()
+	}
+}
+
+// actionRef refers to the working action and sub-actor in messages
+func (c *Context[T]) actionRef() string {
+	switch {
+	case c.named && c.subActor != "":
+		return fmt.Sprintf("%s of %s", c.actionInfo.Name, c.subActor)
+	case c.named:
+		return c.actionInfo.Name
+	default:
+		return c.subActor
+	}
+}
@@ -0,0 +1,127 @@
+// Copyright 2022 Matrix Origin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reconciler
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	. "github.com/onsi/gomega"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	recon "sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+func TestDescribeAction(t *testing.T) {
+	g := NewGomegaWithT(t)
+	obj := &testObject{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "test"}}
+	executed := 0
+	plain := Action[*testObject](func(*Context[*testObject]) error {
+		executed++
+		return nil
+	})
+	named := NamedAction(ActionInfo{Name: "scaleIn", Description: "remove replicas", Idempotent: true}, plain)
+
+	ctx := newTestContext(obj, nil)
+	_, ok := DescribeAction(ctx)
+	g.Expect(ok).To(BeFalse())
+	g.Expect(named(ctx)).To(Succeed())
+	g.Expect(executed).To(Equal(1))
+	info, ok := DescribeAction(ctx)
+	g.Expect(ok).To(BeTrue())
+	g.Expect(info.Name).To(Equal("scaleIn"))
+	g.Expect(info.Idempotent).To(BeTrue())
+	g.Expect(ctx.actionName(named)).To(Equal("scaleIn"))
+
+	ctx = newTestContext(obj, nil)
+	g.Expect(plain(ctx)).To(Succeed())
+	_, ok = DescribeAction(ctx)
+	g.Expect(ok).To(BeFalse())
+	g.Expect(ctx.actionName(plain)).To(ContainSubstring("TestDescribeAction"))
+}
+
+func TestDescribeWrappedAction(t *testing.T) {
+	g := NewGomegaWithT(t)
+	obj := &testObject{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "test"}}
+	named := NamedAction(ActionInfo{Name: "scaleIn", Idempotent: true}, func(*Context[*testObject]) error { return nil })
+
+	// the built-in gates keep the metadata and set their flags
+	ctx := newTestContext(obj, nil)
+	g.Expect(MaintenanceOnly(Disruptive(named))(ctx)).To(Succeed())
+	info, ok := DescribeAction(ctx)
+	g.Expect(ok).To(BeTrue())
+	g.Expect(info.Name).To(Equal("scaleIn"))
+	g.Expect(info.Idempotent).To(BeTrue())
+	g.Expect(info.Disruptive).To(BeTrue())
+	g.Expect(info.MaintenanceOnly).To(BeTrue())
+
+	// so do user wrappers
+	wrapped := 0
+	action := Disruptive(func(ctx *Context[*testObject]) error {
+		wrapped++
+		return named(ctx)
+	})
+	ctx = newTestContext(obj, nil)
+	g.Expect(action(ctx)).To(Succeed())
+	g.Expect(wrapped).To(Equal(1))
+	info, ok = DescribeAction(ctx)
+	g.Expect(ok).To(BeTrue())
+	g.Expect(info.Name).To(Equal("scaleIn"))
+	g.Expect(info.Disruptive).To(BeTrue())
+	g.Expect(ctx.actionName(action)).To(Equal("scaleIn"))
+
+	// a wrapped plain action is reported by its own name rather than the wrapper
+	plain := func(*Context[*testObject]) error { return nil }
+	action = Disruptive(plain)
+	ctx = newTestContext(obj, nil)
+	g.Expect(action(ctx)).To(Succeed())
+	_, ok = DescribeAction(ctx)
+	g.Expect(ok).To(BeFalse())
+	g.Expect(ctx.actionName(action)).To(ContainSubstring("TestDescribeWrappedAction"))
+	g.Expect(ctx.actionName(action)).ToNot(ContainSubstring("Disruptive"))
+}
+
+func TestReconcileNamedAction(t *testing.T) {
+	g := NewGomegaWithT(t)
+	obj := &testObject{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "test", Generation: 1}}
+	var action Action[*testObject]
+	actor := &testActor{ObserveFn: func(*Context[*testObject]) (Action[*testObject], error) {
+		return action, nil
+	}}
+	r, cli := newTestReconciler(t, actor, &options{skipFinalizer: true}, obj)
+	req := recon.Request{NamespacedName: client.ObjectKeyFromObject(obj)}
+	got := &testObject{}
+
+	action = NamedAction(ActionInfo{Name: "upgrade"}, func(*Context[*testObject]) error { return nil })
+	_, err := r.Reconcile(context.Background(), req)
+	g.Expect(err).To(Succeed())
+	g.Expect(cli.Get(context.Background(), req.NamespacedName, got)).To(Succeed())
+	g.Expect(meta.FindStatusCondition(got.GetConditions(), ConditionTypeSynced).Message).To(HaveSuffix("pending action upgrade"))
+
+	action = NamedAction(ActionInfo{Name: "upgrade"}, func(*Context[*testObject]) error { return fmt.Errorf("boom") })
+	_, err = r.Reconcile(context.Background(), req)
+	g.Expect(err).ToNot(Succeed())
+	g.Expect(cli.Get(context.Background(), req.NamespacedName, got)).To(Succeed())
+	g.Expect(meta.FindStatusCondition(got.GetConditions(), ConditionTypeSynced).Message).To(HavePrefix("Last error of upgrade: boom"))
+
+	// flags are enforced
+	action = NamedAction(ActionInfo{Name: "scaleIn", ApprovalPlan: "drop 2 replicas"}, func(*Context[*testObject]) error { return nil })
+	_, err = r.Reconcile(context.Background(), req)
+	g.Expect(err).To(Succeed())
+	g.Expect(cli.Get(context.Background(), req.NamespacedName, got)).To(Succeed())
+	g.Expect(meta.IsStatusConditionTrue(got.GetConditions(), ConditionTypePendingApproval)).To(BeTrue())
+}
@@ -0,0 +1,121 @@
+// Copyright 2022 Matrix Origin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reconciler
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/util/workqueue"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	recon "sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// the per-item backoff of workqueue.DefaultControllerRateLimiter
+const (
+	retryBaseDelay = 5 * time.Millisecond
+	retryMaxDelay  = 1000 * time.Second
+)
+
+// RetryObserver is implemented by objects that publish when the reconciler will retry the object
+// and how many times the reconciliation has failed in a row. The next retry time of a failure is a lower
+// bound, see failureTracker.nextRetry.
+type RetryObserver interface {
+	SetNextRetry(nextRetryTime *metav1.Time, consecutiveFailures int32)
+}
+
+// failureTracker counts the consecutive failures of the objects of a reconciler and predicts the backoff
+// of the rate limiter of the controller
+type failureTracker struct {
+	sync.Mutex
+	failures map[client.ObjectKey]int32
+	// limiter is the per-item backoff of the controller, nil if the controller uses a custom rate limiter
+	limiter workqueue.RateLimiter
+}
+
+func newFailureTracker() *failureTracker {
+	return &failureTracker{failures: map[client.ObjectKey]int32{}}
+}
+
+// setupRateLimiter installs a rate limiter equivalent to the default one of the controller whose backoff
+// can be predicted, unless a custom rate limiter is configured
+func (r *Reconciler[T]) setupRateLimiter() {
+	if r.ctrlOpts.RateLimiter != nil {
+		return
+	}
+	r.failures.limiter = workqueue.NewItemExponentialFailureRateLimiter(retryBaseDelay, retryMaxDelay)
+	r.ctrlOpts.RateLimiter = workqueue.NewMaxOfRateLimiter(r.failures.limiter, workqueue.DefaultControllerRateLimiter())
+}
+
+func (t *failureTracker) failed(key client.ObjectKey) int32 {
+	t.Lock()
+	defer t.Unlock()
+	t.failures[key]++
+	return t.failures[key]
+}
+
+func (t *failureTracker) count(key client.ObjectKey) int32 {
+	if t == nil {
+		return 0
+	}
+	t.Lock()
+	defer t.Unlock()
+	return t.failures[key]
+}
+
+func (t *failureTracker) reset(key client.ObjectKey) {
+	if t == nil {
+		return
+	}
+	t.Lock()
+	defer t.Unlock()
+	delete(t.failures, key)
+}
+
+// nextRetry predicts when the object will be reconciled again after returning res, zero is returned if
+// the time is decided by a custom rate limiter. The backoff of a failure is predicted from the per-item
+// limiter only, the controller takes the max of it and the overall bucket limiter of
+// workqueue.DefaultControllerRateLimiter, so the retry might be later than predicted when the bucket is
+// exhausted, i.e. the returned time is a lower bound.
+func (t *failureTracker) nextRetry(key client.ObjectKey, res recon.Result, now time.Time) time.Time {
+	if res.RequeueAfter > 0 {
+		return now.Add(res.RequeueAfter)
+	}
+	if t == nil || t.limiter == nil {
+		return time.Time{}
+	}
+	// the controller will call When() of the rate limiter, which backs off exponentially by the number of requeues
+	requeues := t.limiter.NumRequeues(recon.Request{NamespacedName: key})
+	backoff := float64(retryBaseDelay.Nanoseconds()) * math.Pow(2, float64(requeues))
+	if backoff > math.MaxInt64 || time.Duration(backoff) > retryMaxDelay {
+		return now.Add(retryMaxDelay)
+	}
+	return now.Add(time.Duration(backoff))
+}
+
+func setNextRetry(obj client.Object, next time.Time, failures int32) {
+	o, ok := obj.(RetryObserver)
+	if !ok {
+		return
+	}
+	if next.IsZero() {
+		o.SetNextRetry(nil, failures)
+		return
+	}
+	t := metav1.NewTime(next)
+	o.SetNextRetry(&t, failures)
+}
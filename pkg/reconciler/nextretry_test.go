@@ -0,0 +1,78 @@
+// Copyright 2022 Matrix Origin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reconciler
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/util/workqueue"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	recon "sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+func TestFailureTrackerNextRetry(t *testing.T) {
+	g := NewGomegaWithT(t)
+	tracker := newFailureTracker()
+	tracker.limiter = workqueue.NewItemExponentialFailureRateLimiter(retryBaseDelay, retryMaxDelay)
+	key := client.ObjectKey{Namespace: "default", Name: "test"}
+	now := time.Now()
+
+	g.Expect(tracker.nextRetry(key, retry, now)).To(Equal(now.Add(retry.RequeueAfter)))
+	for _, want := range []time.Duration{5 * time.Millisecond, 10 * time.Millisecond, 20 * time.Millisecond} {
+		next := tracker.nextRetry(key, backoff, now)
+		// the prediction must match what the controller gets from the rate limiter
+		g.Expect(tracker.limiter.When(recon.Request{NamespacedName: key})).To(Equal(want))
+		g.Expect(next).To(Equal(now.Add(want)))
+	}
+
+	tracker.limiter = nil
+	g.Expect(tracker.nextRetry(key, backoff, now).IsZero()).To(BeTrue())
+}
+
+func TestReconcileNextRetry(t *testing.T) {
+	g := NewGomegaWithT(t)
+	obj := &testObject{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "test"}}
+	var action Action[*testObject]
+	actor := &testActor{ObserveFn: func(*Context[*testObject]) (Action[*testObject], error) {
+		return action, nil
+	}}
+	r, cli := newTestReconciler(t, actor, &options{skipFinalizer: true}, obj)
+	r.failures.limiter = workqueue.NewItemExponentialFailureRateLimiter(retryBaseDelay, retryMaxDelay)
+	req := recon.Request{NamespacedName: client.ObjectKeyFromObject(obj)}
+	got := &testObject{}
+
+	action = func(*Context[*testObject]) error { return fmt.Errorf("boom") }
+	for i := 0; i < 2; i++ {
+		_, err := r.Reconcile(context.Background(), req)
+		g.Expect(err).To(HaveOccurred())
+	}
+	g.Expect(cli.Get(context.Background(), req.NamespacedName, got)).To(Succeed())
+	g.Expect(got.Status.ConsecutiveFailures).To(Equal(int32(2)))
+	g.Expect(got.Status.NextRetryTime).ToNot(BeNil())
+	g.Expect(meta.FindStatusCondition(got.GetConditions(), ConditionTypeSynced).Message).To(ContainSubstring("2 consecutive failures, next retry at"))
+
+	action = nil
+	_, err := r.Reconcile(context.Background(), req)
+	g.Expect(err).To(Succeed())
+	g.Expect(cli.Get(context.Background(), req.NamespacedName, got)).To(Succeed())
+	g.Expect(got.Status.ConsecutiveFailures).To(BeZero())
+	g.Expect(got.Status.NextRetryTime).To(BeNil())
+}
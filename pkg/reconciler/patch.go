@@ -0,0 +1,148 @@
+// Copyright 2022 Matrix Origin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reconciler
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+
+	"gomodules.xyz/jsonpatch/v2"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// PatchStrategy decides how Context.Patch and Context.PatchStatus compute the patch,
+// it can be passed along with other client.PatchOption / client.SubResourcePatchOption.
+type PatchStrategy string
+
+const (
+	// MergePatchWithOptimisticLock computes a JSON merge patch guarded by the resourceVersion of the object, this is
+	// the default strategy
+	MergePatchWithOptimisticLock PatchStrategy = "MergePatchWithOptimisticLock"
+	// MergePatch computes a JSON merge patch without optimistic lock, lists are replaced as a whole
+	MergePatch PatchStrategy = "MergePatch"
+	// StrategicMergePatch computes a strategic merge patch, which merges lists by their patch merge keys
+	// (e.g. containers by name). Only built-in types are supported by the apiserver.
+	StrategicMergePatch PatchStrategy = "StrategicMergePatch"
+	// JSONPatch computes a JSON patch (RFC 6902), each replaced or removed field is guarded by a test operation
+	// against its original value instead of locking the whole object
+	JSONPatch PatchStrategy = "JSONPatch"
+)
+
+func (s PatchStrategy) ApplyToPatch(*client.PatchOptions) {}
+
+func (s PatchStrategy) ApplyToSubResourcePatch(*client.SubResourcePatchOptions) {}
+
+// PatchOutput receives the computed patch when passed as an option, e.g. for logging
+type PatchOutput struct {
+	Type types.PatchType
+	Data []byte
+}
+
+func (o *PatchOutput) ApplyToPatch(*client.PatchOptions) {}
+
+func (o *PatchOutput) ApplyToSubResourcePatch(*client.SubResourcePatchOptions) {}
+
+func patchSettings[O any](opts []O) (PatchStrategy, *PatchOutput) {
+	strategy := MergePatchWithOptimisticLock
+	var out *PatchOutput
+	for _, opt := range opts {
+		switch o := any(opt).(type) {
+		case PatchStrategy:
+			strategy = o
+		case *PatchOutput:
+			out = o
+		}
+	}
+	return strategy, out
+}
+
+func newPatch(strategy PatchStrategy, before client.Object, after client.Object) (client.Patch, error) {
+	switch strategy {
+	case MergePatch:
+		return client.MergeFrom(before), nil
+	case StrategicMergePatch:
+		return client.StrategicMergeFrom(before), nil
+	case JSONPatch:
+		data, err := jsonPatchWithTests(before, after)
+		if err != nil {
+			return nil, err
+		}
+		return client.RawPatch(types.JSONPatchType, data), nil
+	default:
+		return client.MergeFromWithOptions(before, client.MergeFromWithOptimisticLock{}), nil
+	}
+}
+
+// jsonPatchWithTests computes the JSON patch from before to after and prepends a test operation
+// for every replaced or removed path, so that the patch fails if any of these fields has been
+// changed concurrently.
+func jsonPatchWithTests(before client.Object, after client.Object) ([]byte, error) {
+	beforeJSON, err := json.Marshal(before)
+	if err != nil {
+		return nil, err
+	}
+	afterJSON, err := json.Marshal(after)
+	if err != nil {
+		return nil, err
+	}
+	ops, err := jsonpatch.CreatePatch(beforeJSON, afterJSON)
+	if err != nil {
+		return nil, err
+	}
+	var original interface{}
+	if err := json.Unmarshal(beforeJSON, &original); err != nil {
+		return nil, err
+	}
+	// test operations are evaluated against the original document, so they must precede all mutations
+	var tests []jsonpatch.Operation
+	for _, op := range ops {
+		if op.Operation != "replace" && op.Operation != "remove" {
+			continue
+		}
+		if v, ok := lookupJSONPointer(original, op.Path); ok && v != nil {
+			tests = append(tests, jsonpatch.NewOperation("test", op.Path, v))
+		}
+	}
+	return json.Marshal(append(tests, ops...))
+}
+
+func lookupJSONPointer(doc interface{}, pointer string) (interface{}, bool) {
+	if pointer == "" {
+		return doc, true
+	}
+	cur := doc
+	for _, token := range strings.Split(strings.TrimPrefix(pointer, "/"), "/") {
+		token = strings.ReplaceAll(strings.ReplaceAll(token, "~1", "/"), "~0", "~")
+		switch v := cur.(type) {
+		case map[string]interface{}:
+			next, ok := v[token]
+			if !ok {
+				return nil, false
+			}
+			cur = next
+		case []interface{}:
+			i, err := strconv.Atoi(token)
+			if err != nil || i < 0 || i >= len(v) {
+				return nil, false
+			}
+			cur = v[i]
+		default:
+			return nil, false
+		}
+	}
+	return cur, true
+}
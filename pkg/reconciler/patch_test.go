@@ -0,0 +1,94 @@
+// Copyright 2022 Matrix Origin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reconciler
+
+import (
+	"testing"
+
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	kubefake "sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestPatchStrategy(t *testing.T) {
+	tests := []struct {
+		name     string
+		strategy PatchStrategy
+		wantType types.PatchType
+		wantData string
+	}{{
+		name:     "default merge patch with optimistic lock",
+		wantType: types.MergePatchType,
+		wantData: `{"metadata":{"labels":{"a":"b"},"resourceVersion":"999"}}`,
+	}, {
+		name:     "merge patch",
+		strategy: MergePatch,
+		wantType: types.MergePatchType,
+		wantData: `{"metadata":{"labels":{"a":"b"}}}`,
+	}, {
+		name:     "strategic merge patch",
+		strategy: StrategicMergePatch,
+		wantType: types.StrategicMergePatchType,
+		wantData: `{"metadata":{"labels":{"a":"b"}}}`,
+	}, {
+		name:     "json patch with tests",
+		strategy: JSONPatch,
+		wantType: types.JSONPatchType,
+		wantData: `[{"op":"test","path":"/metadata/labels/a","value":"a"},{"op":"replace","path":"/metadata/labels/a","value":"b"}]`,
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewGomegaWithT(t)
+			pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "test", Labels: map[string]string{"a": "a"}}}
+			cli := kubefake.NewClientBuilder().WithObjects(pod).Build()
+			ctx := newTestContext(pod, cli)
+
+			out := &PatchOutput{}
+			mutateFn := func() error {
+				pod.Labels["a"] = "b"
+				return nil
+			}
+			if tt.strategy != "" {
+				g.Expect(ctx.Patch(pod, mutateFn, tt.strategy, out)).To(Succeed())
+			} else {
+				g.Expect(ctx.Patch(pod, mutateFn, out)).To(Succeed())
+			}
+			g.Expect(out.Type).To(Equal(tt.wantType))
+			g.Expect(string(out.Data)).To(Equal(tt.wantData))
+			g.Expect(pod.Labels).To(HaveKeyWithValue("a", "b"))
+		})
+	}
+}
+
+func TestJSONPatchTestFailsOnConcurrentChange(t *testing.T) {
+	g := NewGomegaWithT(t)
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "test", Labels: map[string]string{"a": "a"}}}
+	cli := kubefake.NewClientBuilder().WithObjects(pod).Build()
+	ctx := newTestContext(pod, cli)
+
+	stale := &corev1.Pod{}
+	g.Expect(ctx.Get(types.NamespacedName{Namespace: "default", Name: "test"}, stale)).To(Succeed())
+	g.Expect(ctx.Patch(pod, func() error {
+		pod.Labels["a"] = "c"
+		return nil
+	}, MergePatch)).To(Succeed())
+
+	g.Expect(ctx.Patch(stale, func() error {
+		stale.Labels["a"] = "b"
+		return nil
+	}, JSONPatch)).ToNot(Succeed())
+}
@@ -0,0 +1,115 @@
+// Copyright 2022 Matrix Origin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reconciler
+
+import (
+	"strings"
+
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+)
+
+var (
+	// DefaultIgnoredAnnotations are the annotations written by tools or the reconciler itself that do not
+	// affect the desired state of the object
+	DefaultIgnoredAnnotations = []string{
+		"kubectl.kubernetes.io/last-applied-configuration",
+		"argocd.argoproj.io/*",
+		"meta.helm.sh/*",
+		DeletionPolicyAnnotation,
+	}
+	// DefaultIgnoredLabels are the labels written by tools that do not affect the desired state of the object
+	DefaultIgnoredLabels = []string{
+		"argocd.argoproj.io/*",
+	}
+
+	// alwaysObservedAnnotations request the reconciler to act and are never ignored
+	alwaysObservedAnnotations = []string{
+		ReconcileRequestAnnotation,
+		ApprovalAnnotation,
+	}
+)
+
+// MetadataChangedPredicate passes update events that change annotations or labels other than the ignored ones.
+// An ignored key ending with "*" ignores all the keys with the prefix, e.g. argocd.argoproj.io/*.
+// Changes of the ReconcileRequestAnnotation and the ApprovalAnnotation are never ignored.
+type MetadataChangedPredicate struct {
+	predicate.Funcs
+	IgnoredAnnotations []string
+	IgnoredLabels      []string
+}
+
+func (p MetadataChangedPredicate) Update(e event.UpdateEvent) bool {
+	if e.ObjectOld == nil || e.ObjectNew == nil {
+		return false
+	}
+	oldAnnotations, newAnnotations := e.ObjectOld.GetAnnotations(), e.ObjectNew.GetAnnotations()
+	for _, key := range alwaysObservedAnnotations {
+		if oldAnnotations[key] != newAnnotations[key] {
+			return true
+		}
+	}
+	return !equalIgnoring(oldAnnotations, newAnnotations, p.IgnoredAnnotations) ||
+		!equalIgnoring(e.ObjectOld.GetLabels(), e.ObjectNew.GetLabels(), p.IgnoredLabels)
+}
+
+// WithIgnoredAnnotations ignores changes of the given annotations in addition to the DefaultIgnoredAnnotations,
+// it has no effect if the predicate is overridden by WithPredicate
+func WithIgnoredAnnotations(keys ...string) ApplyOption {
+	return func(o *options) { o.ignoredAnnotations = append(o.ignoredAnnotations, keys...) }
+}
+
+// WithIgnoredLabels ignores changes of the given labels in addition to the DefaultIgnoredLabels,
+// it has no effect if the predicate is overridden by WithPredicate
+func WithIgnoredLabels(keys ...string) ApplyOption {
+	return func(o *options) { o.ignoredLabels = append(o.ignoredLabels, keys...) }
+}
+
+func (o *options) defaultPredicate() predicate.Predicate {
+	return predicate.Or(
+		predicate.GenerationChangedPredicate{},
+		MetadataChangedPredicate{
+			IgnoredAnnotations: append(append([]string{}, DefaultIgnoredAnnotations...), o.ignoredAnnotations...),
+			IgnoredLabels:      append(append([]string{}, DefaultIgnoredLabels...), o.ignoredLabels...),
+		},
+	)
+}
+
+func equalIgnoring(a, b map[string]string, ignored []string) bool {
+	for k, v := range a {
+		if w, ok := b[k]; (!ok || w != v) && !isIgnoredKey(k, ignored) {
+			return false
+		}
+	}
+	for k := range b {
+		if _, ok := a[k]; !ok && !isIgnoredKey(k, ignored) {
+			return false
+		}
+	}
+	return true
+}
+
+func isIgnoredKey(key string, ignored []string) bool {
+	for _, i := range ignored {
+		if strings.HasSuffix(i, "*") {
+			if strings.HasPrefix(key, strings.TrimSuffix(i, "*")) {
+				return true
+			}
+		} else if key == i {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,87 @@
+// Copyright 2022 Matrix Origin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reconciler
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+)
+
+func TestDefaultPredicate(t *testing.T) {
+	opts := &options{ignoredAnnotations: []string{"example.com/*"}, ignoredLabels: []string{"tenant"}}
+	pred := opts.defaultPredicate()
+	tests := []struct {
+		name        string
+		old         metav1.ObjectMeta
+		new         metav1.ObjectMeta
+		wantTrigger bool
+	}{{
+		name:        "generation changed",
+		old:         metav1.ObjectMeta{Generation: 1},
+		new:         metav1.ObjectMeta{Generation: 2},
+		wantTrigger: true,
+	}, {
+		name:        "annotation changed",
+		old:         metav1.ObjectMeta{Annotations: map[string]string{"a": "1"}},
+		new:         metav1.ObjectMeta{Annotations: map[string]string{"a": "2"}},
+		wantTrigger: true,
+	}, {
+		name:        "label removed",
+		old:         metav1.ObjectMeta{Labels: map[string]string{"a": "1"}},
+		new:         metav1.ObjectMeta{},
+		wantTrigger: true,
+	}, {
+		name: "last-applied changed",
+		old:  metav1.ObjectMeta{Annotations: map[string]string{"kubectl.kubernetes.io/last-applied-configuration": "{}"}},
+		new:  metav1.ObjectMeta{Annotations: map[string]string{"kubectl.kubernetes.io/last-applied-configuration": `{"spec":{}}`}},
+	}, {
+		name: "argo tracking id added",
+		old:  metav1.ObjectMeta{},
+		new:  metav1.ObjectMeta{Annotations: map[string]string{"argocd.argoproj.io/tracking-id": "app:/Pod:default/test"}},
+	}, {
+		name: "configured prefix and label",
+		old:  metav1.ObjectMeta{Annotations: map[string]string{"example.com/a": "1"}, Labels: map[string]string{"tenant": "a"}},
+		new:  metav1.ObjectMeta{Annotations: map[string]string{"example.com/a": "2"}, Labels: map[string]string{"tenant": "b"}},
+	}, {
+		name:        "reconcile requested",
+		old:         metav1.ObjectMeta{},
+		new:         metav1.ObjectMeta{Annotations: map[string]string{ReconcileRequestAnnotation: "now"}},
+		wantTrigger: true,
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := pred.Update(event.UpdateEvent{
+				ObjectOld: &corev1.Pod{ObjectMeta: tt.old},
+				ObjectNew: &corev1.Pod{ObjectMeta: tt.new},
+			})
+			if got != tt.wantTrigger {
+				t.Errorf("Update() = %v, want %v", got, tt.wantTrigger)
+			}
+		})
+	}
+}
+
+func TestMetadataChangedPredicateNeverIgnoresRequests(t *testing.T) {
+	pred := MetadataChangedPredicate{IgnoredAnnotations: []string{"reconcile.matrixorigin.io/*"}}
+	if !pred.Update(event.UpdateEvent{
+		ObjectOld: &corev1.Pod{},
+		ObjectNew: &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{ApprovalAnnotation: "scaleIn@1"}}},
+	}) {
+		t.Errorf("approval should never be ignored")
+	}
+}
@@ -0,0 +1,147 @@
+// Copyright 2022 Matrix Origin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reconciler
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const reasonProgressDeadlineExceeded = "ProgressDeadlineExceeded"
+
+// WithProgressDeadline marks the object Stalled, emits a warning event and counts it in the stalled_objects
+// metric if the object keeps executing actions without being synced for longer than d.
+// The deadline restarts when the generation of the object changes and after each deliberate wait, see ErrWait.
+func WithProgressDeadline(d time.Duration) ApplyOption {
+	return func(o *options) { o.progressDeadline = d }
+}
+
+// progressTracker tracks since when the objects of a reconciler have been unsynced, the progress is kept
+// in memory and restarts when the process restarts
+type progressTracker struct {
+	sync.Mutex
+	objects map[client.ObjectKey]*progress
+}
+
+type progress struct {
+	uid        types.UID
+	generation int64
+	since      time.Time
+	stalled    bool
+}
+
+func newProgressTracker() *progressTracker {
+	return &progressTracker{objects: map[client.ObjectKey]*progress{}}
+}
+
+// observe records an unsynced pass of the object and returns for how long the object has been unsynced and
+// whether the object has become stalled in this pass
+func (p *progressTracker) observe(obj client.Object, now time.Time, deadline time.Duration) (time.Duration, bool) {
+	p.Lock()
+	defer p.Unlock()
+	key := client.ObjectKeyFromObject(obj)
+	cur, ok := p.objects[key]
+	if !ok || cur.uid != obj.GetUID() || cur.generation != obj.GetGeneration() {
+		cur = &progress{uid: obj.GetUID(), generation: obj.GetGeneration(), since: now}
+		p.objects[key] = cur
+	}
+	elapsed := now.Sub(cur.since)
+	if cur.stalled || elapsed < deadline {
+		return elapsed, false
+	}
+	cur.stalled = true
+	return elapsed, true
+}
+
+// reset forgets the progress of the object and returns whether it was stalled
+func (p *progressTracker) reset(key client.ObjectKey) bool {
+	p.Lock()
+	defer p.Unlock()
+	cur, ok := p.objects[key]
+	delete(p.objects, key)
+	return ok && cur.stalled
+}
+
+func (p *progressTracker) stalled() int {
+	p.Lock()
+	defer p.Unlock()
+	n := 0
+	for _, cur := range p.objects {
+		if cur.stalled {
+			n++
+		}
+	}
+	return n
+}
+
+// checkProgress is called before executing an action, it marks the object stalled if the progress deadline exceeds
+func (r *Reconciler[T]) checkProgress(ctx *Context[T], action string) {
+	if r.progressDeadline == 0 || r.progress == nil {
+		return
+	}
+	elapsed, justStalled := r.progress.observe(ctx.Obj, time.Now(), r.progressDeadline)
+	if elapsed < r.progressDeadline {
+		// the deadline restarted, e.g. the generation changed
+		clearProgressStalled(ctx.Obj, reasonProgressing, "the object is reconciling")
+		return
+	}
+	msg := fmt.Sprintf("the object has not been synced for %s, action %s keeps repeating", elapsed.Round(time.Second), action)
+	r.trySetCondition(ctx.Obj, metav1.Condition{
+		Type:               ConditionTypeStalled,
+		Status:             metav1.ConditionTrue,
+		ObservedGeneration: ctx.Obj.GetGeneration(),
+		Reason:             reasonProgressDeadlineExceeded,
+		Message:            msg,
+	})
+	if justStalled {
+		ctx.Log.Info("progress deadline exceeded", "action", action, "elapsed", elapsed)
+		ctx.Event.EmitEventGeneric(reasonProgressDeadlineExceeded, msg, fmt.Errorf("progress deadline %s exceeded", r.progressDeadline))
+		stalledObjects.WithLabelValues(r.name).Set(float64(r.progress.stalled()))
+	}
+}
+
+// resetProgress clears the stalled state of the object, which is synced or gone
+func (r *Reconciler[T]) resetProgress(key client.ObjectKey, obj client.Object) {
+	if r.progress == nil || !r.progress.reset(key) {
+		return
+	}
+	stalledObjects.WithLabelValues(r.name).Set(float64(r.progress.stalled()))
+	if obj != nil {
+		clearProgressStalled(obj, reasonSynced, "the object is synced")
+	}
+}
+
+// clearProgressStalled clears the Stalled condition of obj set by the progress deadline
+func clearProgressStalled(obj client.Object, reason string, msg string) {
+	cond, ok := obj.(Conditional)
+	if !ok {
+		return
+	}
+	if c := meta.FindStatusCondition(cond.GetConditions(), ConditionTypeStalled); c != nil && c.Status == metav1.ConditionTrue && c.Reason == reasonProgressDeadlineExceeded {
+		cond.SetCondition(metav1.Condition{
+			Type:               ConditionTypeStalled,
+			Status:             metav1.ConditionFalse,
+			ObservedGeneration: obj.GetGeneration(),
+			Reason:             reason,
+			Message:            msg,
+		})
+	}
+}
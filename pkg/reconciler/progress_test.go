@@ -0,0 +1,136 @@
+// Copyright 2022 Matrix Origin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reconciler
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	recon "sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+func TestProgressTracker(t *testing.T) {
+	g := NewGomegaWithT(t)
+	p := newProgressTracker()
+	obj := &testObject{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "test", Generation: 1}}
+	now := time.Now()
+
+	_, stalled := p.observe(obj, now, time.Minute)
+	g.Expect(stalled).To(BeFalse())
+	elapsed, stalled := p.observe(obj, now.Add(2*time.Minute), time.Minute)
+	g.Expect(elapsed).To(Equal(2 * time.Minute))
+	g.Expect(stalled).To(BeTrue())
+	// only reported once
+	_, stalled = p.observe(obj, now.Add(3*time.Minute), time.Minute)
+	g.Expect(stalled).To(BeFalse())
+	g.Expect(p.stalled()).To(Equal(1))
+
+	// a new generation restarts the deadline
+	obj.Generation = 2
+	elapsed, stalled = p.observe(obj, now.Add(4*time.Minute), time.Minute)
+	g.Expect(elapsed).To(BeZero())
+	g.Expect(stalled).To(BeFalse())
+	g.Expect(p.stalled()).To(Equal(0))
+}
+
+func TestReconcileProgressDeadline(t *testing.T) {
+	g := NewGomegaWithT(t)
+	obj := &testObject{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "stalled", Generation: 1}}
+	var action Action[*testObject]
+	actor := &testActor{ObserveFn: func(*Context[*testObject]) (Action[*testObject], error) {
+		return action, nil
+	}}
+	r, cli := newTestReconciler(t, actor, &options{skipFinalizer: true, progressDeadline: time.Minute}, obj)
+	req := recon.Request{NamespacedName: client.ObjectKeyFromObject(obj)}
+	got := &testObject{}
+
+	action = NamedAction(ActionInfo{Name: "rollout"}, func(*Context[*testObject]) error { return nil })
+	_, err := r.Reconcile(context.Background(), req)
+	g.Expect(err).To(Succeed())
+	// pretend the object has been unsynced for a while
+	r.progress.objects[req.NamespacedName].since = time.Now().Add(-time.Hour)
+	_, err = r.Reconcile(context.Background(), req)
+	g.Expect(err).To(Succeed())
+	g.Expect(cli.Get(context.Background(), req.NamespacedName, got)).To(Succeed())
+	c := meta.FindStatusCondition(got.GetConditions(), ConditionTypeStalled)
+	g.Expect(c).ToNot(BeNil())
+	g.Expect(c.Status).To(Equal(metav1.ConditionTrue))
+	g.Expect(c.Message).To(ContainSubstring("action rollout keeps repeating"))
+	g.Expect(<-r.recorder.(*record.FakeRecorder).Events).To(ContainSubstring(reasonProgressDeadlineExceeded))
+	g.Expect(testutil.ToFloat64(stalledObjects.WithLabelValues(r.name))).To(Equal(float64(1)))
+
+	action = nil
+	_, err = r.Reconcile(context.Background(), req)
+	g.Expect(err).To(Succeed())
+	g.Expect(cli.Get(context.Background(), req.NamespacedName, got)).To(Succeed())
+	g.Expect(meta.IsStatusConditionFalse(got.GetConditions(), ConditionTypeStalled)).To(BeTrue())
+	g.Expect(testutil.ToFloat64(stalledObjects.WithLabelValues(r.name))).To(BeZero())
+}
+
+func TestReconcileProgressDeadlineKeptByKStatus(t *testing.T) {
+	g := NewGomegaWithT(t)
+	obj := &testObject{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "failing", Generation: 1}}
+	actor := &testActor{ObserveFn: func(*Context[*testObject]) (Action[*testObject], error) {
+		return NamedAction(ActionInfo{Name: "rollout"}, func(*Context[*testObject]) error { return fmt.Errorf("boom") }), nil
+	}}
+	r, cli := newTestReconciler(t, actor, &options{skipFinalizer: true, kstatus: true, progressDeadline: time.Minute}, obj)
+	req := recon.Request{NamespacedName: client.ObjectKeyFromObject(obj)}
+	got := &testObject{}
+
+	_, err := r.Reconcile(context.Background(), req)
+	g.Expect(err).To(HaveOccurred())
+	r.progress.objects[req.NamespacedName].since = time.Now().Add(-time.Hour)
+	// the retryable error of the action does not clear the Stalled condition set in the same pass
+	_, err = r.Reconcile(context.Background(), req)
+	g.Expect(err).To(HaveOccurred())
+	g.Expect(cli.Get(context.Background(), req.NamespacedName, got)).To(Succeed())
+	c := meta.FindStatusCondition(got.GetConditions(), ConditionTypeStalled)
+	g.Expect(c.Status).To(Equal(metav1.ConditionTrue))
+	g.Expect(c.Reason).To(Equal(reasonProgressDeadlineExceeded))
+	g.Expect(meta.IsStatusConditionTrue(got.GetConditions(), ConditionTypeReconciling)).To(BeTrue())
+	r.resetProgress(req.NamespacedName, nil)
+}
+
+func TestReconcileProgressDeadlinePausedByWait(t *testing.T) {
+	g := NewGomegaWithT(t)
+	obj := &testObject{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "waiting", Generation: 1}}
+	actor := &testActor{ObserveFn: func(*Context[*testObject]) (Action[*testObject], error) {
+		return NamedAction(ActionInfo{Name: "scaleIn", ApprovalPlan: "drop 2 replicas"}, func(*Context[*testObject]) error { return nil }), nil
+	}}
+	r, cli := newTestReconciler(t, actor, &options{skipFinalizer: true, progressDeadline: time.Minute}, obj)
+	req := recon.Request{NamespacedName: client.ObjectKeyFromObject(obj)}
+	got := &testObject{}
+
+	for i := 0; i < 2; i++ {
+		_, err := r.Reconcile(context.Background(), req)
+		g.Expect(err).To(Succeed())
+		// waiting for the approval for long does not count toward the deadline
+		if p, ok := r.progress.objects[req.NamespacedName]; ok {
+			p.since = time.Now().Add(-time.Hour)
+		}
+	}
+	g.Expect(cli.Get(context.Background(), req.NamespacedName, got)).To(Succeed())
+	g.Expect(meta.IsStatusConditionTrue(got.GetConditions(), ConditionTypePendingApproval)).To(BeTrue())
+	g.Expect(meta.IsStatusConditionTrue(got.GetConditions(), ConditionTypeStalled)).To(BeFalse())
+	g.Expect(r.progress.stalled()).To(BeZero())
+}
@@ -19,6 +19,7 @@ import (
 	"fmt"
 	"time"
 
+	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/utils/strings/slices"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
@@ -26,6 +27,7 @@ import (
 
 	kerr "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/builder"
@@ -76,6 +78,25 @@ type Reconciler[T client.Object] struct {
 	name  string
 	actor Actor[T]
 	newT  func() T
+	gvk   schema.GroupVersionKind
+	// ownedListKinds are the list kinds of ownedTypes
+	ownedListKinds []schema.GroupVersionKind
+	// noStatusSubresource indicates T does not have a status subresource
+	noStatusSubresource bool
+	// asyncOps tracks the running operations of AsyncActions
+	asyncOps *asyncOperations
+	// progress tracks the unsynced objects against the progress deadline
+	progress *progressTracker
+	// breaker is built from the circuitBreaker option
+	breaker *circuitBreaker
+	// failures tracks the consecutive failures to publish the next retry
+	failures *failureTracker
+	// locks is the lock table shared by the reconcilers of the manager
+	locks *keyedLocker
+	// queue is the fair queue built from the fairQueue option
+	queue *fairQueue
+	// recoverPanic is whether the workers of the fair queue recover panics, see controller.Options.RecoverPanic
+	recoverPanic bool
 }
 
 type options struct {
@@ -87,8 +108,32 @@ type options struct {
 	skipFinalizer bool
 	// skipPatchFinalizer indicates reconciler will handle CR deletion but do not patch finalizer
 	skipPatchFinalizer bool
+	// legacyFinalizers are the finalizers previously used by the reconciler, which will be migrated to the current one
+	legacyFinalizers []string
 	// skipStatusSync indicates the reconciler can skip sync status
 	skipStatusSync bool
+	// allowNoStatusSubresource allows the reconciler to sync status by updating the whole object if T does not
+	// have a status subresource
+	allowNoStatusSubresource bool
+	// kstatus indicates the reconciler maintains kstatus-compliant conditions
+	kstatus bool
+	// ownedTypes are the types of objects owned by T
+	ownedTypes []client.Object
+	// watches are the extra watches registered along with T
+	watches []watchFn
+	// concurrencyKey derives the key to serialize reconciles across reconcilers
+	concurrencyKey func(client.Object) string
+	// disruptionBudget limits the concurrent disruptive actions across reconcilers
+	disruptionBudget *DisruptionBudget
+	// progressDeadline is the max duration an object can be unsynced before it is marked stalled
+	progressDeadline time.Duration
+	// circuitBreaker short-circuits actions after consecutive failures
+	circuitBreaker *CircuitBreakerOptions
+	// ignoredAnnotations and ignoredLabels are ignored by the default predicate along with the built-in ones
+	ignoredAnnotations []string
+	ignoredLabels      []string
+	// fairQueue replaces the FIFO workqueue of the controller with a fair queue
+	fairQueue *fairQueueOptions
 
 	pred *predicate.Predicate
 }
@@ -126,6 +171,12 @@ func SkipStatusSync() ApplyOption {
 	return func(o *options) { o.skipStatusSync = true }
 }
 
+// WithLegacyFinalizers declares the finalizer names previously used by the reconciler (e.g. before renaming it),
+// legacy finalizers will be replaced by the current one and be respected when finalizing objects
+func WithLegacyFinalizers(finalizers ...string) ApplyOption {
+	return func(o *options) { o.legacyFinalizers = append(o.legacyFinalizers, finalizers...) }
+}
+
 // Setup register a kubernetes reconciler to the resource kind defined by T.
 // Name is the name of the reconciler, which should be unique across a cluster.
 // Manager represents the kubernetes cluster.
@@ -142,6 +193,12 @@ func Setup[T client.Object](tpl T, name string, mgr ctrl.Manager, actor Actor[T]
 	if err != nil {
 		return err
 	}
+	if err := r.setupStatusSubresource(mgr); err != nil {
+		return err
+	}
+	if err := r.setupFairQueue(mgr); err != nil {
+		return err
+	}
 
 	// register reconciler to the target kubernetes cluster
 	// TODO(aylei): figure out what sub-resources should be owned here
@@ -150,17 +207,17 @@ func Setup[T client.Object](tpl T, name string, mgr ctrl.Manager, actor Actor[T]
 	if opts.buildFn != nil {
 		opts.buildFn(bld)
 	}
+	for _, w := range opts.watches {
+		if err := w(mgr, bld, r.gvk); err != nil {
+			return err
+		}
+	}
 	// ignore status change
 	var filter predicate.Predicate
 	if opts.pred != nil {
 		filter = *opts.pred
 	} else {
-		// default preds
-		filter = predicate.Or(
-			predicate.GenerationChangedPredicate{},
-			predicate.LabelChangedPredicate{},
-			predicate.AnnotationChangedPredicate{},
-		)
+		filter = opts.defaultPredicate()
 	}
 
 	return bld.Named(r.name).
@@ -174,25 +231,55 @@ func newReconciler[T client.Object](tpl T, name string, mgr ctrl.Manager, actor
 		options: opts,
 		Client:  mgr.GetClient(),
 
-		name:  name,
-		actor: actor,
+		name:     name,
+		actor:    actor,
+		asyncOps: newAsyncOperations(),
+		progress: newProgressTracker(),
+		failures: newFailureTracker(),
+	}
+	locks, err := locksOf(mgr)
+	if err != nil {
+		return nil, err
+	}
+	r.locks = locks
+	r.setupRateLimiter()
+	if opts.circuitBreaker != nil {
+		r.breaker = newCircuitBreaker(*opts.circuitBreaker)
 	}
 
 	// resolve go type to GVK and build the factory of T
 	if err := r.setupObjectFactory(mgr.GetScheme(), tpl); err != nil {
 		return nil, err
 	}
+	if err := r.setupOwnedTypes(mgr.GetScheme()); err != nil {
+		return nil, err
+	}
 
 	return r, nil
 }
 
 func (r *Reconciler[T]) Reconcile(goCtx context.Context, req recon.Request) (recon.Result, error) {
+	if r.queue != nil {
+		// the controller only funnels requests into the fair queue, which are reconciled by its own workers
+		r.queue.Add(req)
+		return forget, nil
+	}
+	return r.reconcile(goCtx, req)
+}
+
+func (r *Reconciler[T]) reconcile(goCtx context.Context, req recon.Request) (recon.Result, error) {
 	log := r.logger.WithValues("namespace", req.Namespace, "name", req.Name)
 	log.V(Debug).Info("start reconciling")
 
 	// get the latest spec and status from apiserver and build the action context
 	obj := r.newT()
 	if err := r.Get(goCtx, req.NamespacedName, obj); err != nil {
+		if kerr.IsNotFound(err) {
+			r.releaseDisruption(req.NamespacedName)
+			r.asyncOps.cancelAll(req.NamespacedName)
+			r.resetProgress(req.NamespacedName, nil)
+			r.failures.reset(req.NamespacedName)
+		}
 		// forget the object if it does not exist
 		return forget, util.Ignore(kerr.IsNotFound, err)
 	}
@@ -202,6 +289,26 @@ func (r *Reconciler[T]) Reconcile(goCtx context.Context, req recon.Request) (rec
 		Client:  r.Client,
 		Log:     log,
 		Event:   &EmitEventWrapper{EventRecorder: r.recorder, subject: obj},
+
+		noStatusSubresource: r.noStatusSubresource,
+		disruptionBudget:    r.disruptionBudget,
+		disruptionKey:       disruptionKey(r.gvk.Kind, req.NamespacedName),
+		asyncOps:            r.asyncOps,
+		locker:              r.locks,
+	}
+	defer ctx.unlockAll()
+	if r.concurrencyKey != nil {
+		if err := ctx.Lock(r.concurrencyKey(obj)); err != nil {
+			return backoff, errors.Wrap(err, 0)
+		}
+		// re-read the object since it might have been changed while waiting for the lock
+		if err := r.Get(goCtx, req.NamespacedName, obj); err != nil {
+			return forget, util.Ignore(kerr.IsNotFound, err)
+		}
+	}
+
+	if r.noStatusSubresource {
+		ctx.observed = obj.DeepCopyObject().(client.Object)
 	}
 
 	// optionally transit to deleting state
@@ -231,6 +338,13 @@ func (r *Reconciler[T]) Reconcile(goCtx context.Context, req recon.Request) (rec
 		return backoff, errors.Wrap(err, 0)
 	}
 
+	// operations started for a former spec or a re-created object are obsolete, even if Observe does not
+	// return the AsyncAction again
+	if canceled := r.asyncOps.cancelStale(req.NamespacedName, obj.GetUID(), obj.GetGeneration()); len(canceled) > 0 {
+		ctx.Log.Info("spec changed, cancel async operations", "actions", canceled)
+		setAsyncOperation(obj, nil)
+	}
+
 	action, err := r.actor.Observe(ctx)
 	if err != nil {
 		return r.processActorError(ctx, err)
@@ -242,11 +356,27 @@ func (r *Reconciler[T]) Reconcile(goCtx context.Context, req recon.Request) (rec
 	// now and wait for the next change to be watched or some resync timeouts.
 	if action == nil {
 		ctx.Log.Info("object is synced, reconcile will be triggered on next change or resync")
+		r.releaseDisruption(req.NamespacedName)
 		ctx.Event.EmitEventGeneric(reconcileSuccess, "object is synced", nil)
 
 		if isConditional {
 			cond.SetCondition(synced(true, obj.GetGeneration()))
 		}
+		// the object might be synced without ever acquiring the budget or the maintenance window, e.g. the
+		// spec is reverted
+		clearWaiting(obj, reasonDisruptionBudget, "the object is synced")
+		clearWaiting(obj, reasonMaintenanceWindow, "the object is synced")
+		clearPendingApproval(obj)
+		// operations are not persisted, the recorded one might be lost after the process restarts
+		if !r.asyncOps.tracking(req.NamespacedName) {
+			setAsyncOperation(obj, nil)
+		}
+		r.setKStatus(obj, false, nil)
+		r.resetProgress(req.NamespacedName, obj)
+		r.failures.reset(req.NamespacedName)
+		setNextRetry(obj, time.Time{}, 0)
+		r.setObserved(obj, true)
+		r.ackReconcileRequest(obj)
 		if err := r.updateStatus(ctx); err != nil {
 			if kerr.IsConflict(err) {
 				log.V(Debug).Info("update status conflict, retry", "detail", err.Error())
@@ -257,9 +387,30 @@ func (r *Reconciler[T]) Reconcile(goCtx context.Context, req recon.Request) (rec
 		return forget, nil
 	}
 
+	if r.breaker != nil {
+		breakerKey := r.breakerKey(req.NamespacedName)
+		allowed, wait, probe := r.breaker.allow(breakerKey, time.Now())
+		if !allowed {
+			return r.shortCircuit(ctx, wait)
+		}
+		if probe {
+			// the probe might not reach the action, e.g. the status update conflicts, let another pass probe
+			defer r.breaker.releaseProbe(breakerKey)
+		}
+	}
 	if isConditional {
-		cond.SetCondition(synced(false, obj.GetGeneration()))
+		// the action is named as it executes, keep the message of the last pass
+		if c := meta.FindStatusCondition(cond.GetConditions(), ConditionTypeSynced); c != nil && c.Status == metav1.ConditionFalse {
+			pending := *c
+			pending.ObservedGeneration = obj.GetGeneration()
+			cond.SetCondition(pending)
+		} else {
+			cond.SetCondition(pendingCondition(ctx))
+		}
 	}
+	r.setKStatus(obj, true, nil)
+	setNextRetry(obj, time.Time{}, r.failures.count(req.NamespacedName))
+	r.setObserved(obj, false)
 	if err := r.updateStatus(ctx); err != nil {
 		if kerr.IsConflict(err) {
 			log.V(Debug).Info("update status conflict, retry", "detail", err.Error())
@@ -268,18 +419,58 @@ func (r *Reconciler[T]) Reconcile(goCtx context.Context, req recon.Request) (rec
 		return backoff, errors.Wrap(err, 0)
 	}
 
-	log.V(Debug).Info("execute reconcile action", "action", action)
-	if err := action(ctx); err != nil {
+	before := obj.DeepCopyObject().(client.Object)
+	err = action(ctx)
+	name := ctx.actionName(action)
+	log.V(Debug).Info("executed reconcile action", "action", name, "actor", ctx.subActor, "description", ctx.actionInfo.Description)
+	actionsTotal.WithLabelValues(r.name, ctx.subActor, name).Inc()
+	r.recordActionResult(ctx, err)
+	r.checkProgress(ctx, name)
+	if err != nil {
 		return r.processActorError(ctx, err)
 	}
+	r.failures.reset(req.NamespacedName)
+	if isConditional && ctx.named {
+		cond.SetCondition(pendingCondition(ctx))
+	}
+	// the action might change the status, e.g. an approval or a wait is done
+	if err := r.saveActionStatus(ctx, before); err != nil {
+		if kerr.IsConflict(err) {
+			log.V(Debug).Info("update status conflict, retry", "detail", err.Error())
+			return retry, nil
+		}
+		return backoff, errors.Wrap(err, 0)
+	}
 	// Always retry after a successful action to check what should be done next
 	return retry, nil
 }
 
+// saveActionStatus updates the status changed by a successful action
+func (r *Reconciler[T]) saveActionStatus(ctx *Context[T], before client.Object) error {
+	if r.skipStatusSync {
+		return nil
+	}
+	changed, err := statusChanged(before, ctx.Obj)
+	if err != nil || !changed {
+		return err
+	}
+	return r.updateStatus(ctx)
+}
+
 func (r *Reconciler[T]) updateStatus(ctx *Context[T]) error {
 	if r.skipStatusSync {
 		return nil
 	}
+	if r.noStatusSubresource && ctx.observed != nil {
+		// updating the whole object bumps its generation, skip updates that change nothing to avoid a hot loop
+		changed, err := statusChanged(ctx.observed, ctx.Obj)
+		if err != nil {
+			return err
+		}
+		if !changed {
+			return nil
+		}
+	}
 	return ctx.UpdateStatus(ctx.Obj)
 }
 
@@ -290,14 +481,56 @@ func (r *Reconciler[T]) processActorError(ctx *Context[T], actorErr error) (reco
 	}
 	// 1. record error details
 	obj := ctx.Obj
+	var resync *ReSync
+	isResync := errors.As(actorErr, &resync)
+	key := client.ObjectKeyFromObject(obj)
+	res := backoff
+	var failures int32
+	switch {
+	case isResync:
+		res = recon.Result{Requeue: true, RequeueAfter: resync.RequeueAfter}
+		r.failures.reset(key)
+		if resync.Wait {
+			// a deliberate wait is not a lack of progress, restart the deadline
+			r.resetProgress(key, obj)
+		}
+	case kerr.IsConflict(actorErr):
+		res = retry
+		failures = r.failures.count(key)
+	default:
+		failures = r.failures.failed(key)
+	}
+	next := r.failures.nextRetry(key, res, time.Now())
+	setNextRetry(obj, next, failures)
 	if cond, isConditional := any(obj).(Conditional); isConditional {
+		msg := fmt.Sprintf("Last error: %s", actorErr.Error())
+		if ref := ctx.actionRef(); ref != "" {
+			msg = fmt.Sprintf("Last error of %s: %s", ref, actorErr.Error())
+		}
+		switch {
+		case failures > 0 && !next.IsZero():
+			msg = fmt.Sprintf("%s (%d consecutive failures, next retry at %s)", msg, failures, next.Format(time.RFC3339))
+		case failures > 0:
+			msg = fmt.Sprintf("%s (%d consecutive failures)", msg, failures)
+		}
 		cond.SetCondition(metav1.Condition{
 			Type:               ConditionTypeSynced,
 			Status:             metav1.ConditionFalse,
 			ObservedGeneration: obj.GetGeneration(),
-			Message:            fmt.Sprintf("Last error: %s", actorErr.Error()),
+			Message:            msg,
 		})
 	}
+	// only terminal errors stall the object, others are expected to recover by retrying
+	var terminal *Terminal
+	if errors.As(actorErr, &terminal) {
+		r.setKStatus(obj, false, actorErr)
+	} else {
+		r.setKStatus(obj, true, nil)
+	}
+	if !isResync && !kerr.IsConflict(actorErr) {
+		r.ackReconcileRequest(obj)
+	}
+	r.setObserved(obj, false)
 	if err := r.updateStatus(ctx); err != nil {
 		if kerr.IsConflict(err) {
 			ctx.Log.V(Debug).Info("update status conflict, retry", "detail", err.Error())
@@ -307,20 +540,23 @@ func (r *Reconciler[T]) processActorError(ctx *Context[T], actorErr error) (reco
 	}
 
 	// 2. check whether resync is requested
-	var resync *ReSync
-	if errors.As(actorErr, &resync) {
+	if isResync {
 		// resync error
 		ctx.Log.V(Debug).Info("actor request resync", "detail", resync.Error())
-		return recon.Result{Requeue: true, RequeueAfter: resync.RequeueAfter}, nil
+		return res, nil
 	}
 
 	// 3. for conflict error, just log and retry
 	if kerr.IsConflict(actorErr) {
 		ctx.Log.V(Debug).Info("update conflict in reconcile, retry", "detail", actorErr.Error())
-		return retry, nil
+		return res, nil
 	}
 
-	ctx.Event.EmitEventGeneric(reconcileFail, "failed calling actions", actorErr)
+	if ctx.named {
+		ctx.Event.EmitEventGeneric(reconcileFail, fmt.Sprintf("failed calling action %s", ctx.actionInfo.Name), actorErr)
+	} else {
+		ctx.Event.EmitEventGeneric(reconcileFail, "failed calling actions", actorErr)
+	}
 	// 4. print error stack if using error package "github.com/go-errors/errors"
 	var stackErr *errors.Error
 	if errors.As(actorErr, &stackErr) {
@@ -350,6 +586,14 @@ func (r *Reconciler[T]) finalize(ctx *Context[T]) (recon.Result, error) {
 		// wait other reconcilers to complete there finalizer work, ignore.
 		return forget, nil
 	}
+	policy := GetDeletionPolicy(ctx.Obj)
+	if err := r.validatePolicy(policy); err != nil {
+		ctx.Event.EmitEventGeneric(finalizeFail, "invalid deletion policy", err)
+		ctx.Log.Error(err, "invalid deletion policy, skip finalizing")
+		return backoff, nil
+	}
+	ctx.DeletionPolicy = policy
+	r.asyncOps.cancelAll(client.ObjectKeyFromObject(ctx.Obj))
 	done, err := r.actor.Finalize(ctx)
 	if err != nil {
 		if IsNil(err) {
@@ -369,6 +613,14 @@ func (r *Reconciler[T]) finalize(ctx *Context[T]) (recon.Result, error) {
 		ctx.Log.Info("does not complete finalizing, retry")
 		return retry, nil
 	}
+	if policy == DeletionPolicyOrphan {
+		if err := r.orphanOwnedObjects(ctx); err != nil {
+			ctx.Event.EmitEventGeneric(finalizeFail, "failed to orphan owned objects", err)
+			return retry, nil
+		}
+	}
+	r.releaseDisruption(client.ObjectKeyFromObject(ctx.Obj))
+	r.resetProgress(client.ObjectKeyFromObject(ctx.Obj), nil)
 	ctx.Log.Info("resource finalizing complete, remove finalizer")
 	if err := r.removeFinalizer(ctx, ctx.Obj); err != nil {
 		ctx.Event.EmitEventGeneric(finalizeFail, "failed to remove finalizer", err)
@@ -388,6 +640,7 @@ func (r *Reconciler[T]) setupObjectFactory(scheme *runtime.Scheme, tpl T) error
 		return fmt.Errorf("expected 1 object kind for %T, got %d", tpl, len(gvks))
 	}
 	gvk := gvks[0]
+	r.gvk = gvk
 	// check whether newT() can succeed and return error early to avoid panic
 	_, err = scheme.New(gvk)
 	if err != nil {
@@ -404,6 +657,27 @@ func (r *Reconciler[T]) setupObjectFactory(scheme *runtime.Scheme, tpl T) error
 	return nil
 }
 
+// releaseDisruption releases the disruption budget held by the object, if any
+func (r *Reconciler[T]) releaseDisruption(key client.ObjectKey) {
+	if r.disruptionBudget != nil {
+		r.disruptionBudget.release(disruptionKey(r.gvk.Kind, key))
+	}
+}
+
+// setObserved populates the reconcile bookkeeping of obj if it implements Observed
+func (r *Reconciler[T]) setObserved(obj T, synced bool) {
+	o, ok := any(obj).(Observed)
+	if !ok {
+		return
+	}
+	now := metav1.Now()
+	o.SetObservedGeneration(obj.GetGeneration())
+	o.SetLastReconcileTime(now)
+	if synced {
+		o.SetLastSuccessfulReconcileTime(now)
+	}
+}
+
 func (r *Reconciler[T]) trySetCondition(obj client.Object, c metav1.Condition) {
 	if cond, ok := obj.(Conditional); ok {
 		cond.SetCondition(c)
@@ -411,22 +685,50 @@ func (r *Reconciler[T]) trySetCondition(obj client.Object, c metav1.Condition) {
 	return
 }
 
+// FinalizerName returns the finalizer name used by the reconciler with the given name
+func FinalizerName(reconcilerName string) string {
+	return fmt.Sprintf("%s/%s", finalizerPrefix, reconcilerName)
+}
+
 func (r *Reconciler[T]) finalizer() string {
-	return fmt.Sprintf("%s/%s", finalizerPrefix, r.name)
+	return FinalizerName(r.name)
 }
 
+// hasFinalizer checks whether the obj has the finalizer of current reconciler, either the current or a legacy one
 func (c *Reconciler[T]) hasFinalizer(obj T) bool {
-	return slices.Contains(obj.GetFinalizers(), c.finalizer())
+	if slices.Contains(obj.GetFinalizers(), c.finalizer()) {
+		return true
+	}
+	return c.hasLegacyFinalizer(obj)
+}
+
+func (c *Reconciler[T]) hasLegacyFinalizer(obj T) bool {
+	for _, f := range c.legacyFinalizers {
+		if slices.Contains(obj.GetFinalizers(), f) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *Reconciler[T]) removeLegacyFinalizers(obj T) {
+	for _, f := range c.legacyFinalizers {
+		controllerutil.RemoveFinalizer(obj, f)
+	}
 }
 
 func (c *Reconciler[T]) removeFinalizer(ctx *Context[T], obj T) error {
 	if c.skipPatchFinalizer {
 		return nil
 	}
-	if controllerutil.RemoveFinalizer(obj, c.finalizer()) {
-		return ctx.Update(obj)
+	if !c.hasFinalizer(obj) {
+		return nil
 	}
-	return nil
+	return ctx.UpdateWithRetry(obj, func() error {
+		controllerutil.RemoveFinalizer(obj, c.finalizer())
+		c.removeLegacyFinalizers(obj)
+		return nil
+	})
 }
 
 func (c *Reconciler[T]) ensureFinalizer(ctx *Context[T], obj T) error {
@@ -436,10 +738,26 @@ func (c *Reconciler[T]) ensureFinalizer(ctx *Context[T], obj T) error {
 	if c.skipPatchFinalizer {
 		return nil
 	}
-	if controllerutil.AddFinalizer(obj, c.finalizer()) {
-		return ctx.Update(obj)
+	if slices.Contains(obj.GetFinalizers(), c.finalizer()) && !c.hasLegacyFinalizer(obj) {
+		return nil
 	}
-	return nil
+	return ctx.UpdateWithRetry(obj, func() error {
+		controllerutil.AddFinalizer(obj, c.finalizer())
+		c.removeLegacyFinalizers(obj)
+		return nil
+	})
+}
+
+// pendingCondition is the Synced condition of an object whose action is working
+func pendingCondition[T client.Object](ctx *Context[T]) metav1.Condition {
+	c := synced(false, ctx.Obj.GetGeneration())
+	switch {
+	case ctx.named:
+		c.Message = fmt.Sprintf("%s, pending action %s", c.Message, ctx.actionRef())
+	case ctx.subActor != "":
+		c.Message = fmt.Sprintf("%s, pending action of %s", c.Message, ctx.subActor)
+	}
+	return c
 }
 
 func synced(b bool, generation int64) metav1.Condition {
@@ -16,15 +16,24 @@ package reconciler
 
 import (
 	"context"
+	"fmt"
+	"github.com/go-logr/logr"
 	"github.com/google/go-cmp/cmp"
+	"k8s.io/client-go/tools/record"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	kubefake "sigs.k8s.io/controller-runtime/pkg/client/fake"
 	"sigs.k8s.io/controller-runtime/pkg/manager"
 	recon "sigs.k8s.io/controller-runtime/pkg/reconcile"
 	"testing"
 
 	. "github.com/onsi/gomega"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 )
 
 var _ recon.Reconciler = &Reconciler[client.Object]{}
@@ -80,3 +89,207 @@ func (r *FakeActor) Finalize(ctx *Context[*corev1.Pod]) (done bool, err error) {
 	}
 	return true, nil
 }
+
+func TestReconcileKStatus(t *testing.T) {
+	g := NewGomegaWithT(t)
+	obj := &testObject{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "test", Generation: 2}}
+	var action Action[*testObject]
+	actor := &testActor{ObserveFn: func(*Context[*testObject]) (Action[*testObject], error) {
+		return action, nil
+	}}
+	r, cli := newTestReconciler(t, actor, &options{kstatus: true, skipFinalizer: true}, obj)
+	req := recon.Request{NamespacedName: client.ObjectKeyFromObject(obj)}
+
+	action = func(*Context[*testObject]) error { return nil }
+	_, err := r.Reconcile(context.Background(), req)
+	g.Expect(err).To(Succeed())
+	got := &testObject{}
+	g.Expect(cli.Get(context.Background(), req.NamespacedName, got)).To(Succeed())
+	g.Expect(got.Status.ObservedGeneration).To(Equal(int64(2)))
+	g.Expect(meta.IsStatusConditionTrue(got.GetConditions(), ConditionTypeReconciling)).To(BeTrue())
+	g.Expect(meta.IsStatusConditionFalse(got.GetConditions(), ConditionTypeStalled)).To(BeTrue())
+
+	// transient errors keep the object reconciling
+	action = func(*Context[*testObject]) error { return fmt.Errorf("boom") }
+	_, err = r.Reconcile(context.Background(), req)
+	g.Expect(err).ToNot(Succeed())
+	g.Expect(cli.Get(context.Background(), req.NamespacedName, got)).To(Succeed())
+	g.Expect(meta.IsStatusConditionTrue(got.GetConditions(), ConditionTypeReconciling)).To(BeTrue())
+	g.Expect(meta.IsStatusConditionFalse(got.GetConditions(), ConditionTypeStalled)).To(BeTrue())
+
+	action = func(*Context[*testObject]) error { return ErrTerminal(fmt.Errorf("invalid spec")) }
+	_, err = r.Reconcile(context.Background(), req)
+	g.Expect(err).ToNot(Succeed())
+	g.Expect(cli.Get(context.Background(), req.NamespacedName, got)).To(Succeed())
+	g.Expect(meta.IsStatusConditionFalse(got.GetConditions(), ConditionTypeReconciling)).To(BeTrue())
+	g.Expect(meta.IsStatusConditionTrue(got.GetConditions(), ConditionTypeStalled)).To(BeTrue())
+
+	action = nil
+	_, err = r.Reconcile(context.Background(), req)
+	g.Expect(err).To(Succeed())
+	g.Expect(cli.Get(context.Background(), req.NamespacedName, got)).To(Succeed())
+	g.Expect(meta.IsStatusConditionFalse(got.GetConditions(), ConditionTypeReconciling)).To(BeTrue())
+	g.Expect(meta.IsStatusConditionFalse(got.GetConditions(), ConditionTypeStalled)).To(BeTrue())
+	g.Expect(meta.IsStatusConditionTrue(got.GetConditions(), ConditionTypeSynced)).To(BeTrue())
+}
+
+func TestReconcileObserved(t *testing.T) {
+	g := NewGomegaWithT(t)
+	obj := &testObject{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "test", Generation: 3}}
+	var action Action[*testObject]
+	actor := &testActor{ObserveFn: func(*Context[*testObject]) (Action[*testObject], error) {
+		return action, nil
+	}}
+	r, cli := newTestReconciler(t, actor, &options{skipFinalizer: true}, obj)
+	req := recon.Request{NamespacedName: client.ObjectKeyFromObject(obj)}
+
+	action = func(*Context[*testObject]) error { return nil }
+	_, err := r.Reconcile(context.Background(), req)
+	g.Expect(err).To(Succeed())
+	got := &testObject{}
+	g.Expect(cli.Get(context.Background(), req.NamespacedName, got)).To(Succeed())
+	g.Expect(got.Status.ObservedGeneration).To(Equal(int64(3)))
+	g.Expect(got.Status.LastReconcileTime).ToNot(BeNil())
+	g.Expect(got.Status.LastSuccessfulReconcileTime).To(BeNil())
+
+	action = nil
+	_, err = r.Reconcile(context.Background(), req)
+	g.Expect(err).To(Succeed())
+	g.Expect(cli.Get(context.Background(), req.NamespacedName, got)).To(Succeed())
+	g.Expect(got.Status.LastSuccessfulReconcileTime).ToNot(BeNil())
+}
+
+var testGroupVersion = schema.GroupVersion{Group: "test.matrixorigin.io", Version: "v1"}
+
+type testObject struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Status testObjectStatus `json:"status,omitempty"`
+}
+
+type testObjectStatus struct {
+	ConditionalStatus `json:",inline"`
+	ObservedStatus    `json:",inline"`
+
+	AsyncOperation *AsyncOperationStatus `json:"asyncOperation,omitempty"`
+}
+
+func (o *testObject) SetCondition(c metav1.Condition) {
+	o.Status.SetCondition(c)
+}
+
+func (o *testObject) GetConditions() []metav1.Condition {
+	return o.Status.GetConditions()
+}
+
+func (o *testObject) SetObservedGeneration(generation int64) {
+	o.Status.SetObservedGeneration(generation)
+}
+
+func (o *testObject) SetLastReconcileTime(t metav1.Time) {
+	o.Status.SetLastReconcileTime(t)
+}
+
+func (o *testObject) SetLastSuccessfulReconcileTime(t metav1.Time) {
+	o.Status.SetLastSuccessfulReconcileTime(t)
+}
+
+func (o *testObject) SetLastHandledReconcileAt(requestedAt string) {
+	o.Status.SetLastHandledReconcileAt(requestedAt)
+}
+
+func (o *testObject) SetNextRetry(nextRetryTime *metav1.Time, consecutiveFailures int32) {
+	o.Status.SetNextRetry(nextRetryTime, consecutiveFailures)
+}
+
+func (o *testObject) SetAsyncOperation(op *AsyncOperationStatus) {
+	o.Status.AsyncOperation = op
+}
+
+func (o *testObject) DeepCopyObject() runtime.Object {
+	out := new(testObject)
+	*out = *o
+	o.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	o.Status.ConditionalStatus.DeepCopyInto(&out.Status.ConditionalStatus)
+	o.Status.ObservedStatus.DeepCopyInto(&out.Status.ObservedStatus)
+	out.Status.AsyncOperation = o.Status.AsyncOperation.DeepCopy()
+	return out
+}
+
+type testObjectList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+
+	Items []testObject `json:"items"`
+}
+
+func (l *testObjectList) DeepCopyObject() runtime.Object {
+	out := new(testObjectList)
+	*out = *l
+	l.ListMeta.DeepCopyInto(&out.ListMeta)
+	if l.Items != nil {
+		out.Items = make([]testObject, len(l.Items))
+		for i := range l.Items {
+			out.Items[i] = *l.Items[i].DeepCopyObject().(*testObject)
+		}
+	}
+	return out
+}
+
+func newTestScheme() *runtime.Scheme {
+	s := runtime.NewScheme()
+	_ = corev1.AddToScheme(s)
+	_ = appsv1.AddToScheme(s)
+	_ = batchv1.AddToScheme(s)
+	s.AddKnownTypes(testGroupVersion, &testObject{}, &testObjectList{})
+	metav1.AddToGroupVersion(s, testGroupVersion)
+	return s
+}
+
+func newTestReconciler(t *testing.T, actor Actor[*testObject], opts *options, objs ...client.Object) (*Reconciler[*testObject], client.Client) {
+	s := newTestScheme()
+	cli := kubefake.NewClientBuilder().
+		WithScheme(s).
+		WithObjects(objs...).
+		WithStatusSubresource(&testObject{}).
+		Build()
+	opts.recorder = record.NewFakeRecorder(100)
+	opts.logger = logr.Discard()
+	r := &Reconciler[*testObject]{
+		options:  opts,
+		Client:   cli,
+		name:     "test",
+		actor:    actor,
+		asyncOps: newAsyncOperations(),
+		progress: newProgressTracker(),
+		failures: newFailureTracker(),
+		locks:    newKeyedLocker(),
+	}
+	if err := r.setupObjectFactory(s, &testObject{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.setupOwnedTypes(s); err != nil {
+		t.Fatal(err)
+	}
+	return r, cli
+}
+
+type testActor struct {
+	ObserveFn  func(*Context[*testObject]) (Action[*testObject], error)
+	FinalizeFn func(*Context[*testObject]) (done bool, err error)
+}
+
+func (r *testActor) Observe(ctx *Context[*testObject]) (Action[*testObject], error) {
+	if r.ObserveFn != nil {
+		return r.ObserveFn(ctx)
+	}
+	return nil, nil
+}
+
+func (r *testActor) Finalize(ctx *Context[*testObject]) (done bool, err error) {
+	if r.FinalizeFn != nil {
+		return r.FinalizeFn(ctx)
+	}
+	return true, nil
+}
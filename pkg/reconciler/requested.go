@@ -0,0 +1,56 @@
+// Copyright 2022 Matrix Origin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reconciler
+
+import (
+	"time"
+
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// ReconcileRequestAnnotation requests a reconciliation of the object when its value changes, even if the
+// object is synced. The value is opaque to the reconciler, a timestamp is conventional.
+const ReconcileRequestAnnotation = "reconcile.matrixorigin.io/requestedAt"
+
+// ReconcileRequestAcknowledger is implemented by objects that acknowledge the handled value of the
+// ReconcileRequestAnnotation in status, so that tooling can wait for the requested reconciliation to complete
+type ReconcileRequestAcknowledger interface {
+	SetLastHandledReconcileAt(requestedAt string)
+}
+
+// RequestReconcile sets the ReconcileRequestAnnotation of obj to the current time and returns the value,
+// the caller is responsible for updating obj
+func RequestReconcile(obj client.Object) string {
+	requestedAt := time.Now().Format(time.RFC3339Nano)
+	annotations := obj.GetAnnotations()
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	annotations[ReconcileRequestAnnotation] = requestedAt
+	obj.SetAnnotations(annotations)
+	return requestedAt
+}
+
+// ackReconcileRequest acknowledges the requested reconciliation once the reconciler reaches a result,
+// i.e. the object is synced or the reconcile failed
+func (r *Reconciler[T]) ackReconcileRequest(obj T) {
+	requestedAt, ok := obj.GetAnnotations()[ReconcileRequestAnnotation]
+	if !ok {
+		return
+	}
+	if a, ok := any(obj).(ReconcileRequestAcknowledger); ok {
+		a.SetLastHandledReconcileAt(requestedAt)
+	}
+}
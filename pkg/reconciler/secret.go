@@ -0,0 +1,339 @@
+// Copyright 2022 Matrix Origin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reconciler
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/strings/slices"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// CACertKey is the key of the CA certificate in the CA Secret, and of the CA trust bundle in the TLS Secret
+	CACertKey = "ca.crt"
+	// CAKeyKey is the key of the CA private key in the CA Secret
+	CAKeyKey = "ca.key"
+	// caPreviousCertKey is the key of the rotated CA certificate in the CA Secret, which is kept in the
+	// trust bundle until it expires
+	caPreviousCertKey = "ca-previous.crt"
+
+	certificateRotated = "CertificateRotated"
+
+	passwordLength     = 32
+	passwordCharset    = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+	defaultValidity    = 365 * 24 * time.Hour
+	defaultCAValidity  = 10 * 365 * 24 * time.Hour
+	defaultRenewBefore = 30 * 24 * time.Hour
+)
+
+// EnsureCredentialSecret creates the given Secret owned by the reconciling object with a random credential
+// for each of the keys. Existing credentials are never regenerated.
+func EnsureCredentialSecret(kubeCli KubeClient, secret *corev1.Secret, keys ...string) error {
+	return CreateOwnedOrUpdate(kubeCli, secret, func() error {
+		if secret.Data == nil {
+			secret.Data = map[string][]byte{}
+		}
+		for _, k := range keys {
+			if len(secret.Data[k]) > 0 {
+				continue
+			}
+			p, err := RandomPassword(passwordLength)
+			if err != nil {
+				return err
+			}
+			secret.Data[k] = []byte(p)
+		}
+		return nil
+	})
+}
+
+// RandomPassword generates an alphanumeric password of the given length
+func RandomPassword(length int) (string, error) {
+	b := make([]byte, length)
+	max := big.NewInt(int64(len(passwordCharset)))
+	for i := range b {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		b[i] = passwordCharset[n.Int64()]
+	}
+	return string(b), nil
+}
+
+type TLSSecretOptions struct {
+	// CommonName of the serving certificate, defaults to the first DNS name
+	CommonName string
+	// DNSNames are the SANs of the serving certificate, see ServiceDNSNames
+	DNSNames []string
+	// Validity of the serving certificate, defaults to 1 year
+	Validity time.Duration
+	// CAValidity of the CA certificate, defaults to 10 years
+	CAValidity time.Duration
+	// RenewBefore is how long before expiry the certificates will be rotated, defaults to 30 days
+	RenewBefore time.Duration
+	// CASecretName is the name of the Secret holding the CA, defaults to the name of the serving Secret
+	// suffixed with -ca
+	CASecretName string
+}
+
+// EnsureTLSSecret creates the given Secret owned by ctx.Obj with a serving certificate (tls.crt, tls.key) and the
+// CA trust bundle (ca.crt). The serving certificate is issued by a self-signed CA (ca.crt, ca.key) kept in a separate
+// Secret, so that workloads mounting the serving Secret never get the CA key. Existing material is kept, except that
+// certificates are rotated before expiry and the serving certificate is re-issued when the DNS names change, a
+// CertificateRotated event is emitted on rotation. After the CA is rotated, the previous CA stays in the trust bundle
+// until it expires, so that peers still presenting certificates of the previous CA are trusted.
+func EnsureTLSSecret[T client.Object](ctx *Context[T], secret *corev1.Secret, opts TLSSecretOptions) error {
+	if opts.Validity == 0 {
+		opts.Validity = defaultValidity
+	}
+	if opts.CAValidity == 0 {
+		opts.CAValidity = defaultCAValidity
+	}
+	if opts.RenewBefore == 0 {
+		opts.RenewBefore = defaultRenewBefore
+	}
+	if opts.CommonName == "" && len(opts.DNSNames) > 0 {
+		opts.CommonName = opts.DNSNames[0]
+	}
+	if opts.CASecretName == "" {
+		opts.CASecretName = fmt.Sprintf("%s-ca", secret.Name)
+	}
+	now := time.Now()
+	var rotated []string
+
+	caSecret := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Namespace: secret.Namespace, Name: opts.CASecretName}}
+	var ca *x509.Certificate
+	var caKey crypto.Signer
+	err := CreateOwnedOrUpdate(ctx, caSecret, func() error {
+		rotated = nil
+		if caSecret.Data == nil {
+			caSecret.Data = map[string][]byte{}
+		}
+		if len(caSecret.Data[CAKeyKey]) == 0 {
+			if err := adoptLegacyCA(ctx, secret, caSecret); err != nil {
+				return err
+			}
+		}
+		var err error
+		ca, caKey, err = parseKeyPair(caSecret.Data[CACertKey], caSecret.Data[CAKeyKey])
+		if err == nil && !needRenew(ca, now, opts.RenewBefore) {
+			return nil
+		}
+		previous := ca
+		ca, caKey, err = issueCertificate(&x509.Certificate{
+			Subject:               pkix.Name{CommonName: fmt.Sprintf("%s-ca", ctx.Obj.GetName())},
+			NotBefore:             now,
+			NotAfter:              now.Add(opts.CAValidity),
+			KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+			BasicConstraintsValid: true,
+			IsCA:                  true,
+		}, nil, nil)
+		if err != nil {
+			return err
+		}
+		caSecret.Data[CACertKey], caSecret.Data[CAKeyKey], err = encodeKeyPair(ca, caKey)
+		if err != nil {
+			return err
+		}
+		delete(caSecret.Data, caPreviousCertKey)
+		if previous != nil {
+			rotated = append(rotated, CACertKey)
+			// keep trusting the previous CA until it expires
+			if now.Before(previous.NotAfter) {
+				caSecret.Data[caPreviousCertKey] = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: previous.Raw})
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	bundle := trustBundle(caSecret, now)
+
+	err = CreateOwnedOrUpdate(ctx, secret, func() error {
+		if secret.Data == nil {
+			secret.Data = map[string][]byte{}
+		}
+		if secret.ResourceVersion == "" && secret.Type == "" {
+			secret.Type = corev1.SecretTypeTLS
+		}
+		// the CA key must never be exposed to the workloads mounting the serving Secret
+		delete(secret.Data, CAKeyKey)
+		secret.Data[CACertKey] = bundle
+		leaf, _, err := parseKeyPair(secret.Data[corev1.TLSCertKey], secret.Data[corev1.TLSPrivateKeyKey])
+		if err == nil && !needRenew(leaf, now, opts.RenewBefore) && sameDNSNames(leaf.DNSNames, opts.DNSNames) &&
+			leaf.CheckSignatureFrom(ca) == nil {
+			return nil
+		}
+		if leaf != nil {
+			rotated = append(rotated, corev1.TLSCertKey)
+		}
+		leaf, leafKey, err := issueCertificate(&x509.Certificate{
+			Subject:     pkix.Name{CommonName: opts.CommonName},
+			DNSNames:    opts.DNSNames,
+			NotBefore:   now,
+			NotAfter:    now.Add(opts.Validity),
+			KeyUsage:    x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+			ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		}, ca, caKey)
+		if err != nil {
+			return err
+		}
+		secret.Data[corev1.TLSCertKey], secret.Data[corev1.TLSPrivateKeyKey], err = encodeKeyPair(leaf, leafKey)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if len(rotated) > 0 {
+		ctx.Event.EmitEventGeneric(certificateRotated, fmt.Sprintf("rotated %v in secret %s", rotated, secret.Name), nil)
+	}
+	return nil
+}
+
+// adoptLegacyCA moves the CA that was kept in the serving Secret to the CA Secret
+func adoptLegacyCA[T client.Object](ctx *Context[T], secret *corev1.Secret, caSecret *corev1.Secret) error {
+	legacy := &corev1.Secret{}
+	if err := ctx.Get(client.ObjectKeyFromObject(secret), legacy); err != nil {
+		return client.IgnoreNotFound(err)
+	}
+	if len(legacy.Data[CAKeyKey]) == 0 {
+		return nil
+	}
+	caSecret.Data[CACertKey] = legacy.Data[CACertKey]
+	caSecret.Data[CAKeyKey] = legacy.Data[CAKeyKey]
+	return nil
+}
+
+// trustBundle returns the PEM bundle of the current CA and the previous one if it has not expired
+func trustBundle(caSecret *corev1.Secret, now time.Time) []byte {
+	bundle := append([]byte{}, caSecret.Data[CACertKey]...)
+	if block, _ := pem.Decode(caSecret.Data[caPreviousCertKey]); block != nil {
+		if previous, err := x509.ParseCertificate(block.Bytes); err == nil && now.Before(previous.NotAfter) {
+			bundle = append(bundle, caSecret.Data[caPreviousCertKey]...)
+		}
+	}
+	return bundle
+}
+
+// ServiceDNSNames returns the DNS names of the given Service, which can be used as SANs of its serving certificate.
+// Wildcard names of the pods are included for headless Services.
+func ServiceDNSNames(svc *corev1.Service, clusterDomain string) []string {
+	if clusterDomain == "" {
+		clusterDomain = "cluster.local"
+	}
+	name, ns := svc.Name, svc.Namespace
+	names := []string{
+		name,
+		fmt.Sprintf("%s.%s", name, ns),
+		fmt.Sprintf("%s.%s.svc", name, ns),
+		fmt.Sprintf("%s.%s.svc.%s", name, ns, clusterDomain),
+	}
+	if svc.Spec.ClusterIP == corev1.ClusterIPNone {
+		names = append(names,
+			fmt.Sprintf("*.%s.%s.svc", name, ns),
+			fmt.Sprintf("*.%s.%s.svc.%s", name, ns, clusterDomain),
+		)
+	}
+	return names
+}
+
+func needRenew(cert *x509.Certificate, now time.Time, renewBefore time.Duration) bool {
+	return cert == nil || now.Add(renewBefore).After(cert.NotAfter)
+}
+
+func sameDNSNames(a []string, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for _, n := range b {
+		if !slices.Contains(a, n) {
+			return false
+		}
+	}
+	return true
+}
+
+// issueCertificate signs the template by the parent, the certificate is self-signed if parent is nil
+func issueCertificate(tpl *x509.Certificate, parent *x509.Certificate, parentKey crypto.Signer) (*x509.Certificate, crypto.Signer, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, nil, err
+	}
+	tpl.SerialNumber = serial
+	if parent == nil {
+		parent, parentKey = tpl, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tpl, parent, key.Public(), parentKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, nil, err
+	}
+	return cert, key, nil
+}
+
+func encodeKeyPair(cert *x509.Certificate, key crypto.Signer) ([]byte, []byte, error) {
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw}),
+		pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}),
+		nil
+}
+
+func parseKeyPair(certPEM []byte, keyPEM []byte) (*x509.Certificate, crypto.Signer, error) {
+	certBlock, _ := pem.Decode(certPEM)
+	if certBlock == nil {
+		return nil, nil, fmt.Errorf("no certificate found")
+	}
+	cert, err := x509.ParseCertificate(certBlock.Bytes)
+	if err != nil {
+		return nil, nil, err
+	}
+	keyBlock, _ := pem.Decode(keyPEM)
+	if keyBlock == nil {
+		return cert, nil, fmt.Errorf("no private key found")
+	}
+	key, err := x509.ParsePKCS8PrivateKey(keyBlock.Bytes)
+	if err != nil {
+		return cert, nil, err
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return cert, nil, fmt.Errorf("unsupported private key type %T", key)
+	}
+	return cert, signer, nil
+}
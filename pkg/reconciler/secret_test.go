@@ -0,0 +1,164 @@
+// Copyright 2022 Matrix Origin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reconciler
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	kubefake "sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestEnsureCredentialSecret(t *testing.T) {
+	g := NewGomegaWithT(t)
+	obj := &testObject{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "test", UID: "test-uid"}}
+	cli := kubefake.NewClientBuilder().WithScheme(newTestScheme()).WithObjects(obj).Build()
+	ctx := newTestContext(obj, cli)
+
+	newSecret := func() *corev1.Secret {
+		return &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "credential"}}
+	}
+	s := newSecret()
+	g.Expect(EnsureCredentialSecret(ctx, s, "root")).To(Succeed())
+	root := s.Data["root"]
+	g.Expect(root).To(HaveLen(passwordLength))
+
+	s = newSecret()
+	g.Expect(EnsureCredentialSecret(ctx, s, "root", "admin")).To(Succeed())
+	g.Expect(s.Data["root"]).To(Equal(root))
+	g.Expect(s.Data["admin"]).To(HaveLen(passwordLength))
+}
+
+func TestEnsureTLSSecret(t *testing.T) {
+	g := NewGomegaWithT(t)
+	obj := &testObject{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "test", UID: "test-uid"}}
+	cli := kubefake.NewClientBuilder().WithScheme(newTestScheme()).WithObjects(obj).Build()
+	recorder := record.NewFakeRecorder(10)
+	ctx := newTestContext(obj, cli)
+	ctx.Event = &EmitEventWrapper{EventRecorder: recorder, subject: obj}
+
+	svc := &corev1.Service{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "test"}}
+	newSecret := func() *corev1.Secret {
+		return &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "tls"}}
+	}
+	opts := TLSSecretOptions{DNSNames: ServiceDNSNames(svc, "")}
+
+	s := newSecret()
+	g.Expect(EnsureTLSSecret(ctx, s, opts)).To(Succeed())
+	g.Expect(s.Type).To(Equal(corev1.SecretTypeTLS))
+	g.Expect(s.Data).ToNot(HaveKey(CAKeyKey))
+	caSecret := &corev1.Secret{}
+	g.Expect(cli.Get(context.Background(), client.ObjectKey{Namespace: "default", Name: "tls-ca"}, caSecret)).To(Succeed())
+	ca, _, err := parseKeyPair(caSecret.Data[CACertKey], caSecret.Data[CAKeyKey])
+	g.Expect(err).To(Succeed())
+	g.Expect(s.Data[CACertKey]).To(Equal(caSecret.Data[CACertKey]))
+	leaf, _, err := parseKeyPair(s.Data[corev1.TLSCertKey], s.Data[corev1.TLSPrivateKeyKey])
+	g.Expect(err).To(Succeed())
+	g.Expect(leaf.DNSNames).To(ContainElement("test.default.svc.cluster.local"))
+	pool := x509.NewCertPool()
+	pool.AddCert(ca)
+	_, err = leaf.Verify(x509.VerifyOptions{DNSName: "test.default.svc", Roots: pool})
+	g.Expect(err).To(Succeed())
+
+	// existing material is kept
+	origin := s.Data[corev1.TLSCertKey]
+	s = newSecret()
+	g.Expect(EnsureTLSSecret(ctx, s, opts)).To(Succeed())
+	g.Expect(s.Data[corev1.TLSCertKey]).To(Equal(origin))
+	g.Expect(recorder.Events).To(BeEmpty())
+
+	// rotate the serving certificate before expiry
+	opts.RenewBefore = 2 * defaultValidity
+	opts.CAValidity = 4 * defaultValidity
+	s = newSecret()
+	g.Expect(EnsureTLSSecret(ctx, s, opts)).To(Succeed())
+	g.Expect(s.Data[corev1.TLSCertKey]).ToNot(Equal(origin))
+	g.Expect(recorder.Events).To(Receive(ContainSubstring(certificateRotated)))
+}
+
+func TestEnsureTLSSecretRotateCA(t *testing.T) {
+	g := NewGomegaWithT(t)
+	obj := &testObject{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "test", UID: "test-uid"}}
+	cli := kubefake.NewClientBuilder().WithScheme(newTestScheme()).WithObjects(obj).Build()
+	recorder := record.NewFakeRecorder(10)
+	ctx := newTestContext(obj, cli)
+	ctx.Event = &EmitEventWrapper{EventRecorder: recorder, subject: obj}
+	newSecret := func() *corev1.Secret {
+		return &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "tls"}}
+	}
+	opts := TLSSecretOptions{DNSNames: []string{"test"}}
+
+	s := newSecret()
+	g.Expect(EnsureTLSSecret(ctx, s, opts)).To(Succeed())
+	oldCA := s.Data[CACertKey]
+
+	// the CA is due to renew
+	opts.RenewBefore = 2 * defaultCAValidity
+	s = newSecret()
+	g.Expect(EnsureTLSSecret(ctx, s, opts)).To(Succeed())
+	pool := x509.NewCertPool()
+	g.Expect(pool.AppendCertsFromPEM(oldCA)).To(BeTrue())
+	leaf, _, err := parseKeyPair(s.Data[corev1.TLSCertKey], s.Data[corev1.TLSPrivateKeyKey])
+	g.Expect(err).To(Succeed())
+	// the serving certificate is re-issued by the new CA
+	_, err = leaf.Verify(x509.VerifyOptions{DNSName: "test", Roots: pool})
+	g.Expect(err).ToNot(Succeed())
+	// both CAs are trusted during rotation
+	g.Expect(bytes.HasPrefix(s.Data[CACertKey], oldCA)).To(BeFalse())
+	g.Expect(bytes.Contains(s.Data[CACertKey], oldCA)).To(BeTrue())
+	bundle := x509.NewCertPool()
+	g.Expect(bundle.AppendCertsFromPEM(s.Data[CACertKey])).To(BeTrue())
+	_, err = leaf.Verify(x509.VerifyOptions{DNSName: "test", Roots: bundle})
+	g.Expect(err).To(Succeed())
+	g.Expect(recorder.Events).To(Receive(ContainSubstring(CACertKey)))
+}
+
+func TestEnsureTLSSecretAdoptLegacyCA(t *testing.T) {
+	g := NewGomegaWithT(t)
+	obj := &testObject{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "test", UID: "test-uid"}}
+	ca, caKey, err := issueCertificate(&x509.Certificate{
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().Add(defaultCAValidity),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}, nil, nil)
+	g.Expect(err).To(Succeed())
+	caPEM, caKeyPEM, err := encodeKeyPair(ca, caKey)
+	g.Expect(err).To(Succeed())
+	legacy := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "tls"},
+		Data:       map[string][]byte{CACertKey: caPEM, CAKeyKey: caKeyPEM},
+	}
+	cli := kubefake.NewClientBuilder().WithScheme(newTestScheme()).WithObjects(obj, legacy).Build()
+	ctx := newTestContext(obj, cli)
+	ctx.Event = &EmitEventWrapper{EventRecorder: record.NewFakeRecorder(10), subject: obj}
+
+	s := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "tls"}}
+	g.Expect(EnsureTLSSecret(ctx, s, TLSSecretOptions{DNSNames: []string{"test"}})).To(Succeed())
+	g.Expect(s.Data).ToNot(HaveKey(CAKeyKey))
+	g.Expect(s.Data[CACertKey]).To(Equal(caPEM))
+	caSecret := &corev1.Secret{}
+	g.Expect(cli.Get(context.Background(), client.ObjectKey{Namespace: "default", Name: "tls-ca"}, caSecret)).To(Succeed())
+	g.Expect(caSecret.Data[CAKeyKey]).To(Equal(caKeyPEM))
+}
@@ -0,0 +1,120 @@
+// Copyright 2022 Matrix Origin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reconciler
+
+import (
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/discovery"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// AllowUpdateWithoutStatusSubresource makes the reconciler sync status by updating the whole object if T does
+// not have a status subresource, instead of failing Setup. Note that every update of such an object bumps its
+// generation, so the reconciler skips status updates that change nothing but the bookkeeping fields, and the
+// features keyed on the generation are rejected: Setup fails with WithProgressDeadline, and RunJobStep and
+// AsyncAction return a Terminal error.
+func AllowUpdateWithoutStatusSubresource() ApplyOption {
+	return func(o *options) { o.allowNoStatusSubresource = true }
+}
+
+// setupStatusSubresource checks whether T has a status subresource and decides how status is synced
+func (r *Reconciler[T]) setupStatusSubresource(mgr ctrl.Manager) error {
+	if r.skipStatusSync {
+		return nil
+	}
+	dc, err := discovery.NewDiscoveryClientForConfig(mgr.GetConfig())
+	if err != nil {
+		return err
+	}
+	ok, err := hasStatusSubresource(dc, mgr.GetRESTMapper(), r.gvk)
+	if err != nil {
+		return err
+	}
+	if ok {
+		r.logger.V(Info).Info("sync status via the status subresource", "kind", r.gvk.Kind)
+		return nil
+	}
+	if !r.allowNoStatusSubresource {
+		return fmt.Errorf("kind %s does not have a status subresource, enable it in the CRD or set AllowUpdateWithoutStatusSubresource", r.gvk)
+	}
+	if r.progressDeadline > 0 {
+		return fmt.Errorf("kind %s does not have a status subresource, the progress deadline cannot be tracked across generations bumped by status updates", r.gvk)
+	}
+	r.logger.Info("status subresource not found, sync status by updating the whole object", "kind", r.gvk.Kind)
+	r.noStatusSubresource = true
+	return nil
+}
+
+func hasStatusSubresource(dc discovery.DiscoveryInterface, mapper meta.RESTMapper, gvk schema.GroupVersionKind) (bool, error) {
+	mapping, err := mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+	if err != nil {
+		return false, err
+	}
+	resources, err := dc.ServerResourcesForGroupVersion(gvk.GroupVersion().String())
+	if err != nil {
+		return false, err
+	}
+	status := mapping.Resource.Resource + "/status"
+	for _, r := range resources.APIResources {
+		if r.Name == status {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// statusChanged reports whether the status of obj differs from the observed one, ignoring the fields that change
+// on each reconcile (the reconcile and retry times) or on each update of an object without a status subresource
+// (observedGeneration)
+func statusChanged(observed client.Object, obj client.Object) (bool, error) {
+	before, err := comparableStatus(observed)
+	if err != nil {
+		return false, err
+	}
+	after, err := comparableStatus(obj)
+	if err != nil {
+		return false, err
+	}
+	return !equality.Semantic.DeepEqual(before, after), nil
+}
+
+func comparableStatus(obj client.Object) (interface{}, error) {
+	u, err := runtime.DefaultUnstructuredConverter.ToUnstructured(obj)
+	if err != nil {
+		return nil, err
+	}
+	status, ok := u["status"].(map[string]interface{})
+	if !ok {
+		return u["status"], nil
+	}
+	delete(status, "lastReconcileTime")
+	delete(status, "lastSuccessfulReconcileTime")
+	delete(status, "nextRetryTime")
+	delete(status, "observedGeneration")
+	if conds, ok := status["conditions"].([]interface{}); ok {
+		for _, c := range conds {
+			if m, ok := c.(map[string]interface{}); ok {
+				delete(m, "observedGeneration")
+			}
+		}
+	}
+	return status, nil
+}
@@ -0,0 +1,127 @@
+// Copyright 2022 Matrix Origin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reconciler
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	. "github.com/onsi/gomega"
+	batchv1 "k8s.io/api/batch/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	fakediscovery "k8s.io/client-go/discovery/fake"
+	clienttesting "k8s.io/client-go/testing"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	kubefake "sigs.k8s.io/controller-runtime/pkg/client/fake"
+	recon "sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+func TestHasStatusSubresource(t *testing.T) {
+	g := NewGomegaWithT(t)
+	gvk := testGroupVersion.WithKind("testObject")
+	mapper := meta.NewDefaultRESTMapper(nil)
+	mapper.Add(gvk, meta.RESTScopeNamespace)
+	dc := &fakediscovery.FakeDiscovery{Fake: &clienttesting.Fake{}}
+	dc.Resources = []*metav1.APIResourceList{{
+		GroupVersion: testGroupVersion.String(),
+		APIResources: []metav1.APIResource{{Name: "testobjects"}},
+	}}
+
+	ok, err := hasStatusSubresource(dc, mapper, gvk)
+	g.Expect(err).To(Succeed())
+	g.Expect(ok).To(BeFalse())
+
+	dc.Resources[0].APIResources = append(dc.Resources[0].APIResources, metav1.APIResource{Name: "testobjects/status"})
+	ok, err = hasStatusSubresource(dc, mapper, gvk)
+	g.Expect(err).To(Succeed())
+	g.Expect(ok).To(BeTrue())
+}
+
+func TestUpdateStatusWithoutSubresource(t *testing.T) {
+	g := NewGomegaWithT(t)
+	obj := &testObject{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "test"}}
+	cli := kubefake.NewClientBuilder().WithScheme(newTestScheme()).WithObjects(obj).Build()
+	ctx := newTestContext(obj, cli)
+
+	obj.Status.ObservedGeneration = 1
+	g.Expect(ctx.UpdateStatus(obj)).ToNot(Succeed())
+
+	ctx.noStatusSubresource = true
+	g.Expect(ctx.UpdateStatus(obj)).To(Succeed())
+	got := &testObject{}
+	g.Expect(cli.Get(context.Background(), client.ObjectKeyFromObject(obj), got)).To(Succeed())
+	g.Expect(got.Status.ObservedGeneration).To(Equal(int64(1)))
+}
+
+func TestReconcileWithoutStatusSubresource(t *testing.T) {
+	g := NewGomegaWithT(t)
+	obj := &testObject{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "test", Generation: 1}}
+	r, _ := newTestReconciler(t, &testActor{}, &options{skipFinalizer: true})
+	cli := kubefake.NewClientBuilder().WithScheme(newTestScheme()).WithObjects(obj).Build()
+	r.Client = cli
+	r.noStatusSubresource = true
+	req := recon.Request{NamespacedName: client.ObjectKeyFromObject(obj)}
+
+	_, err := r.Reconcile(context.Background(), req)
+	g.Expect(err).To(Succeed())
+	got := &testObject{}
+	g.Expect(cli.Get(context.Background(), req.NamespacedName, got)).To(Succeed())
+	g.Expect(meta.IsStatusConditionTrue(got.GetConditions(), ConditionTypeSynced)).To(BeTrue())
+	rv := got.ResourceVersion
+
+	// nothing but the bookkeeping fields changes, the object is not updated again
+	_, err = r.Reconcile(context.Background(), req)
+	g.Expect(err).To(Succeed())
+	g.Expect(cli.Get(context.Background(), req.NamespacedName, got)).To(Succeed())
+	g.Expect(got.ResourceVersion).To(Equal(rv))
+}
+
+func TestReconcileActionWithoutStatusSubresource(t *testing.T) {
+	g := NewGomegaWithT(t)
+	obj := &testObject{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "test", Generation: 1}}
+	var action Action[*testObject]
+	actor := &testActor{ObserveFn: func(*Context[*testObject]) (Action[*testObject], error) {
+		return action, nil
+	}}
+	r, _ := newTestReconciler(t, actor, &options{skipFinalizer: true})
+	cli := kubefake.NewClientBuilder().WithScheme(newTestScheme()).WithObjects(obj).Build()
+	r.Client = cli
+	r.noStatusSubresource = true
+	req := recon.Request{NamespacedName: client.ObjectKeyFromObject(obj)}
+	got := &testObject{}
+
+	// a repeating action does not update the object on each pass
+	action = NamedAction(ActionInfo{Name: "rollout"}, func(*Context[*testObject]) error { return nil })
+	_, err := r.Reconcile(context.Background(), req)
+	g.Expect(err).To(Succeed())
+	g.Expect(cli.Get(context.Background(), req.NamespacedName, got)).To(Succeed())
+	rv := got.ResourceVersion
+	for i := 0; i < 2; i++ {
+		_, err = r.Reconcile(context.Background(), req)
+		g.Expect(err).To(Succeed())
+	}
+	g.Expect(cli.Get(context.Background(), req.NamespacedName, got)).To(Succeed())
+	g.Expect(got.ResourceVersion).To(Equal(rv))
+
+	// the features keyed on the generation are rejected
+	action = func(ctx *Context[*testObject]) error {
+		return RunJobStep(ctx, "bootstrap", &batchv1.Job{}, JobStepOptions{})
+	}
+	_, err = r.Reconcile(context.Background(), req)
+	var terminal *Terminal
+	g.Expect(errors.As(err, &terminal)).To(BeTrue())
+}
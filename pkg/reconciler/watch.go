@@ -0,0 +1,123 @@
+// Copyright 2022 Matrix Origin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reconciler
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/go-logr/logr"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	recon "sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+const referenceIndexPrefix = ".matrixorigin.io/references"
+
+// watchFn registers an extra watch for the reconciler of the given gvk
+type watchFn func(mgr ctrl.Manager, bld *builder.Builder, gvk schema.GroupVersionKind) error
+
+// WatchReferenced watches the objects of the given kind that are referenced by T, indexFn returns the keys of the
+// objects referenced by a T. A field index from T to the referenced keys is registered, and all the T referring to
+// a changed object will be enqueued. Only one WatchReferenced is allowed for each kind.
+func WatchReferenced[T client.Object](kind client.Object, indexFn func(T) []client.ObjectKey) ApplyOption {
+	return func(o *options) {
+		o.watches = append(o.watches, func(mgr ctrl.Manager, bld *builder.Builder, gvk schema.GroupVersionKind) error {
+			scheme := mgr.GetScheme()
+			refGVK, err := apiutil.GVKForObject(kind, scheme)
+			if err != nil {
+				return err
+			}
+			v, err := scheme.New(gvk)
+			if err != nil {
+				return err
+			}
+			if _, ok := v.(T); !ok {
+				return fmt.Errorf("index function of %s expects %T, got %T", refGVK.Kind, *new(T), v)
+			}
+			newList, err := listFactory(scheme, gvk)
+			if err != nil {
+				return err
+			}
+			field := referenceIndexField(refGVK.GroupKind())
+			if err := mgr.GetFieldIndexer().IndexField(context.Background(), v.(client.Object), field, referenceIndexer(indexFn)); err != nil {
+				return err
+			}
+			bld.Watches(kind, handler.EnqueueRequestsFromMapFunc(enqueueReferrers(mgr.GetClient(), field, newList, mgr.GetLogger())))
+			return nil
+		})
+	}
+}
+
+func referenceIndexField(gk schema.GroupKind) string {
+	return fmt.Sprintf("%s/%s", referenceIndexPrefix, gk.String())
+}
+
+func referenceIndexer[T client.Object](indexFn func(T) []client.ObjectKey) client.IndexerFunc {
+	return func(obj client.Object) []string {
+		t, ok := obj.(T)
+		if !ok {
+			return nil
+		}
+		var keys []string
+		for _, key := range indexFn(t) {
+			keys = append(keys, key.String())
+		}
+		return keys
+	}
+}
+
+// enqueueReferrers maps a referenced object to the requests of all the objects referring to it
+func enqueueReferrers(cli client.Reader, field string, newList func() client.ObjectList, log logr.Logger) handler.MapFunc {
+	return func(ctx context.Context, obj client.Object) []recon.Request {
+		list := newList()
+		if err := cli.List(ctx, list, client.MatchingFields{field: client.ObjectKeyFromObject(obj).String()}); err != nil {
+			log.Error(err, "error listing referrers", "field", field, "object", client.ObjectKeyFromObject(obj))
+			return nil
+		}
+		items, err := meta.ExtractList(list)
+		if err != nil {
+			log.Error(err, "error extracting referrers", "field", field)
+			return nil
+		}
+		var reqs []recon.Request
+		for _, item := range items {
+			reqs = append(reqs, recon.Request{NamespacedName: client.ObjectKeyFromObject(item.(client.Object))})
+		}
+		return reqs
+	}
+}
+
+// listFactory builds the factory of the list type of the given gvk
+func listFactory(scheme *runtime.Scheme, gvk schema.GroupVersionKind) (func() client.ObjectList, error) {
+	listGVK := gvk.GroupVersion().WithKind(gvk.Kind + "List")
+	if _, err := scheme.New(listGVK); err != nil {
+		return nil, err
+	}
+	return func() client.ObjectList {
+		v, err := scheme.New(listGVK)
+		// must not return error with guard check above, so panic here
+		if err != nil {
+			panic(err)
+		}
+		return v.(client.ObjectList)
+	}, nil
+}
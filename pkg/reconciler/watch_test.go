@@ -0,0 +1,57 @@
+// Copyright 2022 Matrix Origin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reconciler
+
+import (
+	"context"
+	"testing"
+
+	"github.com/go-logr/logr"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	kubefake "sigs.k8s.io/controller-runtime/pkg/client/fake"
+	recon "sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+func TestEnqueueReferrers(t *testing.T) {
+	g := NewGomegaWithT(t)
+	s := newTestScheme()
+	field := referenceIndexField(corev1.SchemeGroupVersion.WithKind("ConfigMap").GroupKind())
+	indexFn := func(o *testObject) []client.ObjectKey {
+		if ref := o.Annotations["ref"]; ref != "" {
+			return []client.ObjectKey{{Namespace: o.Namespace, Name: ref}}
+		}
+		return nil
+	}
+	referrer := func(name, ref string) *testObject {
+		return &testObject{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: name, Annotations: map[string]string{"ref": ref}}}
+	}
+	cli := kubefake.NewClientBuilder().
+		WithScheme(s).
+		WithObjects(referrer("a", "cm"), referrer("b", "cm"), referrer("c", "other")).
+		WithIndex(&testObject{}, field, referenceIndexer(indexFn)).
+		Build()
+	newList, err := listFactory(s, testGroupVersion.WithKind("testObject"))
+	g.Expect(err).To(Succeed())
+
+	mapFn := enqueueReferrers(cli, field, newList, logr.Discard())
+	cm := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "cm"}}
+	g.Expect(mapFn(context.Background(), cm)).To(ConsistOf(
+		recon.Request{NamespacedName: client.ObjectKey{Namespace: "default", Name: "a"}},
+		recon.Request{NamespacedName: client.ObjectKey{Namespace: "default", Name: "b"}},
+	))
+}
@@ -0,0 +1,67 @@
+package util
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCronScheduleNext(t *testing.T) {
+	// 2023-06-07 is a Wednesday
+	base := time.Date(2023, 6, 7, 10, 30, 0, 0, time.UTC)
+	tests := []struct {
+		name string
+		spec string
+		want time.Time
+	}{{
+		name: "every minute",
+		spec: "* * * * *",
+		want: time.Date(2023, 6, 7, 10, 31, 0, 0, time.UTC),
+	}, {
+		name: "daily",
+		spec: "@daily",
+		want: time.Date(2023, 6, 8, 0, 0, 0, 0, time.UTC),
+	}, {
+		name: "saturday 2am",
+		spec: "0 2 * * 6",
+		want: time.Date(2023, 6, 10, 2, 0, 0, 0, time.UTC),
+	}, {
+		name: "sunday as 7",
+		spec: "0 2 * * 7",
+		want: time.Date(2023, 6, 11, 2, 0, 0, 0, time.UTC),
+	}, {
+		name: "steps and lists",
+		spec: "*/20 9,11 * * *",
+		want: time.Date(2023, 6, 7, 11, 0, 0, 0, time.UTC),
+	}, {
+		name: "range",
+		spec: "15 10-12 1 * *",
+		want: time.Date(2023, 7, 1, 10, 15, 0, 0, time.UTC),
+	}, {
+		name: "day of month or day of week",
+		spec: "0 0 15 * 5",
+		want: time.Date(2023, 6, 9, 0, 0, 0, 0, time.UTC),
+	}, {
+		name: "never",
+		spec: "0 0 30 2 *",
+		want: time.Time{},
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := ParseCron(tt.spec)
+			if err != nil {
+				t.Fatalf("ParseCron() error = %v", err)
+			}
+			if got := s.Next(base); !got.Equal(tt.want) {
+				t.Errorf("Next() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseCronInvalid(t *testing.T) {
+	for _, spec := range []string{"", "* * * *", "60 * * * *", "* * * * 8", "*/0 * * * *", "a * * * *", "5-1 * * * *"} {
+		if _, err := ParseCron(spec); err == nil {
+			t.Errorf("ParseCron(%q) expected error", spec)
+		}
+	}
+}